@@ -0,0 +1,283 @@
+package mig
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// batchOptions are the options of a '-- +mig Batch' directive, such as
+//
+//	-- +mig Batch size=5000 key=id sleep=100ms
+//
+// table defaults to the table of the UPDATE or DELETE statement.
+type batchOptions struct {
+	size  int64
+	key   string
+	table string
+	sleep time.Duration
+}
+
+var (
+	batchTableRegexp = regexp.MustCompile("(?is)^(?:UPDATE|DELETE\\s+FROM)\\s+((?:`[^`]+`|\\w+)(?:\\.(?:`[^`]+`|\\w+))?)")
+	whereRegexp      = regexp.MustCompile(`(?i)\bWHERE\b`)
+	orderLimitRegexp = regexp.MustCompile(`(?i)\b(?:ORDER\s+BY|LIMIT)\b`)
+)
+
+// parseBatchOptions parses the options of a '-- +mig Batch' directive.
+func parseBatchOptions(options string) (batchOptions, error) {
+	o := batchOptions{size: 1000}
+
+	for _, field := range strings.Fields(options) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return o, fmt.Errorf("invalid batch option %q", field)
+		}
+
+		var err error
+		switch kv[0] {
+		case "size":
+			o.size, err = strconv.ParseInt(kv[1], 10, 64)
+			if err == nil && o.size <= 0 {
+				err = fmt.Errorf("must be greater than zero")
+			}
+		case "key":
+			o.key = kv[1]
+		case "table":
+			o.table = kv[1]
+		case "sleep":
+			o.sleep, err = time.ParseDuration(kv[1])
+		default:
+			err = fmt.Errorf("unknown option")
+		}
+		if err != nil {
+			return o, fmt.Errorf("invalid batch option %q: %v", field, err)
+		}
+	}
+
+	if len(o.key) == 0 {
+		return o, fmt.Errorf("batch requires a key option")
+	}
+
+	return o, nil
+}
+
+// stripComments removes the comment lines and trailing semicolon of a query.
+func stripComments(query string) string {
+	var lines []string
+	for _, line := range strings.Split(query, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.TrimSuffix(strings.TrimSpace(strings.Join(lines, "\n")), ";")
+}
+
+// topLevel reports, for each byte of a query, whether it is outside of
+// quotes and parentheses, such as those of subqueries.
+func topLevel(query string) []bool {
+	top := make([]bool, len(query))
+	depth := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			continue
+		case c == '(':
+			depth++
+			continue
+		case c == ')':
+			depth--
+			continue
+		}
+		top[i] = depth == 0
+	}
+
+	return top
+}
+
+// findTopLevel returns the location of the first match of re in query
+// outside of quotes and parentheses, or nil if there is none.
+func findTopLevel(query string, re *regexp.Regexp) []int {
+	top := topLevel(query)
+	for _, loc := range re.FindAllStringIndex(query, -1) {
+		if top[loc[0]] {
+			return loc
+		}
+	}
+
+	return nil
+}
+
+// batchQuery restricts an UPDATE or DELETE statement to the range of keys
+// given as its two last parameters, and returns the table it applies to.
+// The range is added to the top level WHERE clause, ignoring those of
+// subqueries, and statements with ORDER BY or LIMIT are rejected.
+func batchQuery(query string, o batchOptions) (string, string, error) {
+	query = stripComments(query)
+
+	table := o.table
+	if len(table) == 0 {
+		m := batchTableRegexp.FindStringSubmatch(query)
+		if m == nil {
+			return "", "", fmt.Errorf("batch requires an UPDATE or DELETE statement, or a table option")
+		}
+		table = m[1]
+	}
+
+	// the range of keys is appended to the statement or its WHERE clause,
+	// which would not restrict it if anything followed that clause
+	if findTopLevel(query, orderLimitRegexp) != nil {
+		return "", "", fmt.Errorf("batch does not support statements with ORDER BY or LIMIT")
+	}
+
+	condition := fmt.Sprintf("%s >= ? AND %s < ?", o.key, o.key)
+
+	loc := findTopLevel(query, whereRegexp)
+	if loc == nil {
+		return query + " WHERE " + condition, table, nil
+	}
+
+	return fmt.Sprintf("%sWHERE %s AND (%s)", query[:loc[0]], condition, strings.TrimSpace(query[loc[1]:])), table, nil
+}
+
+// statementCompleted is the next key recorded along with the batch progress
+// of a migration once a statement, and every statement preceding it, are
+// committed: before a statement run outside of the migration transaction,
+// and once an online schema change or a batched statement completes.
+const statementCompleted = math.MaxInt64
+
+// completedStatements returns the index of the last statement of stmts
+// recorded as committed by an earlier, interrupted, run of the migration,
+// or -1 if there is none.
+func completedStatements(ctx context.Context, db sqlConn, v int64, direction bool, stmts []sqlStatement) (int, error) {
+	last := -1
+	for i, stmt := range stmts {
+		_, isOnline := stmt.directive("OnlineSchemaChange")
+		_, isBatch := stmt.directive("Batch")
+		if !isOnline && !isBatch {
+			continue
+		}
+
+		// the statement preceding it is recorded when committed before it runs
+		for j := i - 1; j <= i; j++ {
+			if j < 0 {
+				continue
+			}
+
+			var next int64
+			err := db.QueryRowContext(ctx, getDialect().batchProgressQuery(), v, direction, j).Scan(&next)
+			if err == sql.ErrNoRows {
+				continue
+			} else if err != nil {
+				return -1, err
+			}
+			if next == statementCompleted {
+				last = j
+			}
+		}
+	}
+
+	return last, nil
+}
+
+// sqlConn is a connection pool, or a single connection,
+// queries and transactions run on.
+type sqlConn interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// runBatch executes an UPDATE or DELETE statement annotated with
+// '-- +mig Batch' in ranges of keys, committing each range along with the
+// progress made, so that an interrupted migration resumes where it stopped.
+func runBatch(db sqlConn, v int64, direction bool, index int, query, options string) error {
+	o, err := parseBatchOptions(options)
+	if err != nil {
+		return err
+	}
+
+	chunk, table, err := batchQuery(query, o)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	var min, max sql.NullInt64
+	q := fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM %s", o.key, o.key, table)
+	if err := db.QueryRowContext(ctx, q).Scan(&min, &max); err != nil {
+		return err
+	}
+	if !min.Valid {
+		return nil
+	}
+
+	d := getDialect()
+	start := min.Int64
+
+	var next int64
+	err = db.QueryRowContext(ctx, d.batchProgressQuery(), v, direction, index).Scan(&next)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil && next > start {
+		Log.Write([]byte(fmt.Sprintf("Resuming  batch %d of %d at %s %d\n", index, v, o.key, next)))
+		start = next
+	}
+
+	for lo := start; lo <= max.Int64; lo += o.size {
+		hi := lo + o.size
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.Exec(chunk, lo, hi)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error executing batch at %s %d: %w", o.key, lo, err)
+		}
+
+		if _, err := tx.Exec(d.upsertBatchProgressSQL(), v, direction, index, hi); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		affected, _ := res.RowsAffected()
+		Log.Write([]byte(fmt.Sprintf("Batch     %s %d..%d of %d, %d rows\n", o.key, lo, hi-1, max.Int64, affected)))
+
+		if hi > max.Int64 {
+			break
+		}
+
+		if o.sleep > 0 {
+			time.Sleep(o.sleep)
+		}
+		if err := throttle(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
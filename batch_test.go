@@ -0,0 +1,159 @@
+package mig
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBatchOptions(t *testing.T) {
+
+	o, err := parseBatchOptions("size=5000 key=id sleep=100ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.size != 5000 || o.key != "id" || o.sleep != 100*time.Millisecond {
+		t.Errorf("incorrect options. got %+v", o)
+	}
+
+	for _, options := range []string{"size=10", "key=id size=0", "key=id size", "key=id foo=bar"} {
+		if _, err := parseBatchOptions(options); err == nil {
+			t.Errorf("expected an error for options %q", options)
+		}
+	}
+}
+
+func TestBatchQuery(t *testing.T) {
+
+	type testData struct {
+		query string
+		table string
+		chunk string
+	}
+
+	tests := []testData{
+		{
+			query: "-- +mig Batch size=5000 key=id\nUPDATE orders SET status_v2 = status;\n",
+			table: "orders",
+			chunk: "UPDATE orders SET status_v2 = status WHERE id >= ? AND id < ?",
+		},
+		{
+			query: "update `shop`.`orders` set status_v2 = 1\nwhere status = 2 or status = 3;\n",
+			table: "`shop`.`orders`",
+			chunk: "update `shop`.`orders` set status_v2 = 1\nWHERE id >= ? AND id < ? AND (status = 2 or status = 3)",
+		},
+		{
+			query: "DELETE FROM sessions WHERE expired = 1;",
+			table: "sessions",
+			chunk: "DELETE FROM sessions WHERE id >= ? AND id < ? AND (expired = 1)",
+		},
+		{
+			query: "UPDATE orders SET total = (SELECT SUM(price) FROM items WHERE items.order_id = orders.id);",
+			table: "orders",
+			chunk: "UPDATE orders SET total = (SELECT SUM(price) FROM items WHERE items.order_id = orders.id) WHERE id >= ? AND id < ?",
+		},
+		{
+			query: "UPDATE orders SET note = 'where' WHERE status IN (SELECT id FROM statuses WHERE closed = 1);",
+			table: "orders",
+			chunk: "UPDATE orders SET note = 'where' WHERE id >= ? AND id < ? AND (status IN (SELECT id FROM statuses WHERE closed = 1))",
+		},
+	}
+
+	for _, test := range tests {
+		chunk, table, err := batchQuery(test.query, batchOptions{size: 10, key: "id"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunk != test.chunk || table != test.table {
+			t.Errorf("incorrect batch query. got %q on %q, want %q on %q", chunk, table, test.chunk, test.table)
+		}
+	}
+
+	for _, query := range []string{
+		"DELETE FROM sessions WHERE expired = 1 ORDER BY id LIMIT 100;",
+		"UPDATE orders SET status = 2 limit 10;",
+	} {
+		if _, _, err := batchQuery(query, batchOptions{size: 10, key: "id"}); err == nil {
+			t.Errorf("expected an error for query %q", query)
+		}
+	}
+}
+
+func TestRunBatchResume(t *testing.T) {
+
+	var chunks [][]int64
+	var progress []int64
+	db := sql.OpenDB(&fakeDB{handle: func(query string, args []driver.NamedValue) ([][]driver.Value, error) {
+		switch {
+		case strings.HasPrefix(query, "SELECT MIN(id), MAX(id) FROM orders"):
+			return [][]driver.Value{{int64(1), int64(35)}}, nil
+		case strings.HasPrefix(query, "SELECT next_key FROM mig_migrations_batches"):
+			// an earlier run committed the keys up to 20
+			return [][]driver.Value{{int64(21)}}, nil
+		case strings.HasPrefix(query, "UPDATE orders"):
+			chunks = append(chunks, []int64{args[0].Value.(int64), args[1].Value.(int64)})
+			return nil, nil
+		case strings.HasPrefix(query, "INSERT INTO mig_migrations_batches"):
+			progress = append(progress, args[3].Value.(int64))
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unexpected query %s", query)
+	}})
+	defer db.Close()
+
+	if err := runBatch(db, 2, true, 1, "UPDATE orders SET status_v2 = status;", "size=10 key=id"); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := [][]int64{{21, 31}, {31, 41}}; !reflect.DeepEqual(chunks, want) {
+		t.Errorf("incorrect chunks. got %v, want %v", chunks, want)
+	}
+	if want := []int64{31, 41}; !reflect.DeepEqual(progress, want) {
+		t.Errorf("incorrect progress. got %v, want %v", progress, want)
+	}
+}
+
+func TestCompletedStatements(t *testing.T) {
+
+	stmts, err := splitSQLStatements(strings.NewReader(directivetxt), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		recorded map[int64]int64 // next key recorded by statement
+		want     int
+	}{
+		{map[int64]int64{}, -1},
+		// the statement preceding the online schema change was committed
+		{map[int64]int64{0: statementCompleted}, 0},
+		{map[int64]int64{0: statementCompleted, 1: statementCompleted}, 1},
+		{map[int64]int64{0: statementCompleted, 1: 42}, 0},
+	}
+
+	for _, test := range tests {
+		db := sql.OpenDB(&fakeDB{handle: func(query string, args []driver.NamedValue) ([][]driver.Value, error) {
+			if !strings.HasPrefix(query, "SELECT next_key FROM mig_migrations_batches") {
+				return nil, fmt.Errorf("unexpected query %s", query)
+			}
+			if next, ok := test.recorded[args[2].Value.(int64)]; ok {
+				return [][]driver.Value{{next}}, nil
+			}
+			return nil, nil
+		}})
+
+		got, err := completedStatements(context.Background(), db, 2, true, stmts)
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("recorded %v: incorrect statement. got %v, want %v", test.recorded, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package mig
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CheckProblem is a mistake found in a migration file by Check
+type CheckProblem struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (p CheckProblem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// checkDirectives reports the unknown '-- +mig' directives of a script,
+// and returns the line of its first Up directive, or 1 if there is none.
+func checkDirectives(path string, b []byte) ([]CheckProblem, int) {
+	var problems []CheckProblem
+	upLine := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, strings.TrimSpace(sqlCmdPrefix)) {
+			continue
+		}
+
+		fields := strings.Fields(line[len(strings.TrimSpace(sqlCmdPrefix)):])
+		if len(fields) == 0 || !strings.HasPrefix(line, sqlCmdPrefix) {
+			problems = append(problems, CheckProblem{File: path, Line: lineNo, Message: fmt.Sprintf("malformed directive %q", line)})
+			continue
+		}
+
+		if !knownDirectives[fields[0]] {
+			problems = append(problems, CheckProblem{File: path, Line: lineNo, Message: fmt.Sprintf("unknown directive %q", fields[0])})
+		}
+		if fields[0] == "Up" && upLine == 0 {
+			upLine = lineNo
+		}
+	}
+
+	if upLine == 0 {
+		upLine = 1
+	}
+
+	return problems, upLine
+}
+
+// checkFile validates a single migration script.
+func checkFile(path string) []CheckProblem {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return []CheckProblem{{File: path, Line: 1, Message: err.Error()}}
+	}
+
+	problems, upLine := checkDirectives(path, b)
+
+	if _, err := sessionVariables(b); err != nil {
+		line := 1
+		if e, ok := err.(*splitError); ok {
+			line, err = e.line, fmt.Errorf("%s", e.msg)
+		}
+		problems = append(problems, CheckProblem{File: path, Line: line, Message: err.Error()})
+	}
+
+	for _, direction := range []bool{true, false} {
+		name := "Up"
+		if !direction {
+			name = "Down"
+		}
+
+		stmts, err := splitSQLStatements(bytes.NewReader(b), direction)
+		if err != nil {
+			line := 1
+			if e, ok := err.(*splitError); ok {
+				line, err = e.line, fmt.Errorf("%s", e.msg)
+			}
+			problems = append(problems, CheckProblem{File: path, Line: line, Message: fmt.Sprintf("%s: %v", name, err)})
+			continue
+		}
+
+		if direction && len(stmts) == 0 {
+			problems = append(problems, CheckProblem{File: path, Line: upLine, Message: "Up section has no statements"})
+		}
+
+		for _, stmt := range stmts {
+			if options, ok := stmt.directive("Batch"); ok {
+				if _, err := parseBatchOptions(options); err != nil {
+					problems = append(problems, CheckProblem{File: path, Line: stmt.line, Message: fmt.Sprintf("%s: %v", name, err)})
+				}
+			}
+			if _, ok := stmt.directive("OnlineSchemaChange"); ok {
+				if _, _, _, err := parseAlterTable(stmt.query); err != nil {
+					problems = append(problems, CheckProblem{File: path, Line: stmt.line, Message: fmt.Sprintf("%s: %v", name, err)})
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// Check validates every migration file in dir without touching a database:
+// file names, duplicate versions, both directions splitting into statements,
+// empty Up sections and unknown directives. Every problem found is reported.
+func Check(dir string) ([]CheckProblem, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var problems []CheckProblem
+	versions := map[int64]string{}
+
+	for _, path := range files {
+		v, err := numericComponent(path)
+		if err != nil {
+			problems = append(problems, CheckProblem{File: path, Line: 1, Message: fmt.Sprintf("invalid file name: %v", err)})
+		} else if other, ok := versions[v]; ok {
+			problems = append(problems, CheckProblem{File: path, Line: 1, Message: fmt.Sprintf("duplicate version %d, also used by %s", v, filepath.Base(other))})
+		} else {
+			versions[v] = path
+		}
+
+		problems = append(problems, checkFile(path)...)
+	}
+
+	return problems, nil
+}
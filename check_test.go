@@ -0,0 +1,84 @@
+package mig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "mig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"1_ok.sql": `-- +mig Up
+CREATE TABLE post (id int NOT NULL);
+
+-- +mig Down
+DROP TABLE post;
+`,
+		"01_duplicate.sql": `-- +mig Up
+CREATE TABLE comment (id int NOT NULL);
+`,
+		"2_broken.sql": `-- +mig Up
+-- +mig Destructiv
+DROP TABLE comment;
+
+-- +mig Down
+-- +mig StatementBegin
+CREATE TABLE comment (id int NOT NULL);
+`,
+		"3_empty.sql": `-- +mig Up
+
+-- +mig Down
+SELECT 1
+`,
+		"4_set.sql": `-- +mig Set lock_wait_timeout=5
+-- +mig Set sql_mode
+-- +mig Up
+SELECT 1;
+`,
+		"noversion.sql": `-- +mig Up
+SELECT 1;
+`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	problems, err := Check(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type want struct {
+		file string
+		line int
+	}
+	wants := []want{
+		{"1_ok.sql", 1},      // duplicate of 01_duplicate.sql
+		{"2_broken.sql", 2},  // unknown directive
+		{"2_broken.sql", 6},  // no StatementEnd
+		{"3_empty.sql", 1},   // empty Up
+		{"3_empty.sql", 4},   // missing semicolon
+		{"4_set.sql", 2},     // Set without a value
+		{"noversion.sql", 1}, // no separator
+	}
+
+	if len(problems) != len(wants) {
+		t.Fatalf("incorrect number of problems. got %v, want %v", problems, wants)
+	}
+	for i, w := range wants {
+		got := problems[i]
+		if filepath.Base(got.File) != w.file || got.Line != w.line {
+			t.Errorf("incorrect problem. got %v, want %v", got, w)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/satriahrh/mig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check [dir]",
+	Short: "Validate migration files without touching a database",
+	Long: `Validate migration files without touching a database: file names,
+duplicate versions, Up and Down sections splitting into statements, empty Up
+sections and unknown directives. Exits non-zero when a problem is found.`,
+	Example: `$ mig check migrations
+$ mig check -d migrations`,
+	Args:         cobra.MaximumNArgs(1),
+	RunE:         checkRunE,
+	SilenceUsage: true,
+}
+
+func init() {
+	checkCmd.Flags().StringP("dir", "d", ".", "directory with migration files")
+
+	rootCmd.AddCommand(checkCmd)
+	checkCmd.PreRun = func(*cobra.Command, []string) {
+		viper.BindPFlags(checkCmd.Flags())
+	}
+}
+
+func checkRunE(cmd *cobra.Command, args []string) error {
+	problems, err := mig.Check(getDirArgs(args))
+	if err != nil {
+		return err
+	}
+
+	if len(problems) == 0 {
+		fmt.Println("No problems found")
+		return nil
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+
+	return fmt.Errorf("%d problems found", len(problems))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// execute runs the mig command line with args.
+func execute(args ...string) error {
+	viper.Reset()
+	defer viper.Reset()
+
+	rootCmd.SetArgs(args)
+	return rootCmd.Execute()
+}
+
+// writeFiles writes files to a new directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestCheckCommand(t *testing.T) {
+
+	broken := writeFiles(t, map[string]string{"1_broken.sql": "-- +mig Up\nCREATE TABLE post (id int)\n"})
+	valid := writeFiles(t, map[string]string{"1_post.sql": "-- +mig Up\nCREATE TABLE post (id int);\n"})
+
+	tests := []struct {
+		args     []string
+		wantFail bool
+	}{
+		{[]string{"check", broken}, true},
+		{[]string{"check", "-d", broken}, true},
+		{[]string{"check", valid}, false},
+		{[]string{"check", valid, broken}, true},
+	}
+
+	for _, test := range tests {
+		if err := execute(test.args...); (err != nil) != test.wantFail {
+			t.Errorf("mig %v: incorrect error %v", test.args, err)
+		}
+	}
+}
+
+func TestLintCommand(t *testing.T) {
+
+	unsafe := writeFiles(t, map[string]string{"1_drop.sql": "-- +mig Up\nDROP TABLE post;\n\n-- +mig Down\nCREATE TABLE post (id int);\n"})
+	safe := writeFiles(t, map[string]string{"1_post.sql": "-- +mig Up\nCREATE TABLE IF NOT EXISTS post (id int);\n\n-- +mig Down\nDROP TABLE IF EXISTS post;\n"})
+
+	tests := []struct {
+		args     []string
+		wantFail bool
+	}{
+		{[]string{"lint", unsafe}, true},
+		{[]string{"lint", "-d", unsafe}, true},
+		{[]string{"lint", safe}, false},
+		{[]string{"lint", safe, unsafe}, true},
+	}
+
+	for _, test := range tests {
+		if err := execute(test.args...); (err != nil) != test.wantFail {
+			t.Errorf("mig %v: incorrect error %v", test.args, err)
+		}
+	}
+}
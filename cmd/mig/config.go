@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/satriahrh/mig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// loadConfig reads the config file and the MIG_* environment variables.
+//
+// Settings are looked up from flags first, then environment variables such
+// as MIG_DSN or MIG_LOCK_TIMEOUT, then the environment of the config file
+// selected with --env or MIG_ENV, and last the top level of the config file:
+//
+//	dir: migrations
+//	environments:
+//	  dev:
+//	    dsn: user:password@tcp(localhost:3306)/app
+//	  prod:
+//	    dsn: user:password@tcp(db.internal:3306)/app
+//	    lock-timeout: 5m
+//	    protected: true
+func loadConfig(cmd *cobra.Command, args []string) error {
+	viper.BindPFlags(cmd.Flags())
+	viper.SetEnvPrefix("mig")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
+
+	path := viper.GetString("config")
+	if len(path) > 0 {
+		viper.SetConfigFile(path)
+	} else {
+		viper.SetConfigName("mig")
+		viper.AddConfigPath(".")
+	}
+
+	if err := viper.ReadInConfig(); err != nil {
+		// the config file is optional unless given explicitly
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok || len(path) > 0 {
+			return fmt.Errorf("error reading config: %v", err)
+		}
+	}
+
+	if env := viper.GetString("env"); len(env) > 0 {
+		key := "environments." + env
+		if !viper.IsSet(key) {
+			return fmt.Errorf("unknown environment %q", env)
+		}
+
+		// merged into the top level of the config file, the environment
+		// overrides it but ranks below flags and environment variables
+		if err := viper.MergeConfigMap(viper.GetStringMap(key)); err != nil {
+			return fmt.Errorf("error reading environment %q: %v", env, err)
+		}
+	}
+
+	if dialect := viper.GetString("dialect"); len(dialect) > 0 && dialect != "mysql" {
+		return fmt.Errorf("unsupported dialect %q, mig supports mysql only", dialect)
+	}
+
+	mig.VersionTable = viper.GetString("table")
+	mig.LockTimeout = viper.GetDuration("lock-timeout")
+	mig.IgnoreUnknown = viper.GetBool("ignore-unknown")
+
+	return nil
+}
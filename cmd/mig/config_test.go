@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/satriahrh/mig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func TestLoadConfig(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "mig.yaml")
+	config := `dir: top
+dsn: user:password@tcp(top:3306)/app
+protected: false
+environments:
+  dev:
+    dir: profile
+    protected: true
+    dsn: user:password@tcp(dev:3306)/app
+    lock-timeout: 5m
+  other:
+    dialect: postgres
+`
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(table string, timeout time.Duration) {
+		mig.VersionTable, mig.LockTimeout = table, timeout
+		viper.Reset()
+	}(mig.VersionTable, mig.LockTimeout)
+
+	tests := []struct {
+		name          string
+		flags         map[string]string
+		env           map[string]string
+		wantDir       string
+		wantDSN       string
+		wantProtected bool
+		wantTimeout   time.Duration
+		wantFail      bool
+	}{
+		{
+			name:        "flag defaults",
+			flags:       map[string]string{"config": path},
+			wantDir:     "top",
+			wantDSN:     "user:password@tcp(top:3306)/app",
+			wantTimeout: time.Minute,
+		},
+		{
+			name:          "environment over top level and flag defaults",
+			flags:         map[string]string{"config": path, "env": "dev"},
+			wantDir:       "profile",
+			wantDSN:       "user:password@tcp(dev:3306)/app",
+			wantProtected: true,
+			wantTimeout:   5 * time.Minute,
+		},
+		{
+			name:          "environment selected with MIG_ENV",
+			flags:         map[string]string{"config": path},
+			env:           map[string]string{"MIG_ENV": "dev"},
+			wantDir:       "profile",
+			wantDSN:       "user:password@tcp(dev:3306)/app",
+			wantProtected: true,
+			wantTimeout:   5 * time.Minute,
+		},
+		{
+			name:          "environment variables over config file",
+			flags:         map[string]string{"config": path, "env": "dev"},
+			env:           map[string]string{"MIG_DIR": "env", "MIG_DSN": "user@tcp(env:3306)/app", "MIG_LOCK_TIMEOUT": "10s"},
+			wantDir:       "env",
+			wantDSN:       "user@tcp(env:3306)/app",
+			wantProtected: true,
+			wantTimeout:   10 * time.Second,
+		},
+		{
+			name:          "flags over environment variables",
+			flags:         map[string]string{"config": path, "env": "dev", "dir": "flag", "lock-timeout": "20s"},
+			env:           map[string]string{"MIG_DIR": "env", "MIG_LOCK_TIMEOUT": "10s"},
+			wantDir:       "flag",
+			wantDSN:       "user:password@tcp(dev:3306)/app",
+			wantProtected: true,
+			wantTimeout:   20 * time.Second,
+		},
+		{
+			name:     "unknown environment",
+			flags:    map[string]string{"config": path, "env": "prod"},
+			wantFail: true,
+		},
+		{
+			name:     "unsupported dialect",
+			flags:    map[string]string{"config": path, "env": "other"},
+			wantFail: true,
+		},
+		{
+			name:     "missing config file given explicitly",
+			flags:    map[string]string{"config": path + ".missing"},
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		viper.Reset()
+
+		cmd := &cobra.Command{Use: "test"}
+		cmd.Flags().String("config", "", "")
+		cmd.Flags().String("env", "", "")
+		cmd.Flags().StringP("dir", "d", ".", "")
+		cmd.Flags().String("table", mig.VersionTable, "")
+		cmd.Flags().Bool("ignore-unknown", false, "")
+		cmd.Flags().Duration("lock-timeout", time.Minute, "")
+		for k, v := range test.flags {
+			if err := cmd.Flags().Set(k, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for k, v := range test.env {
+			os.Setenv(k, v)
+		}
+
+		err := loadConfig(cmd, nil)
+		dir, dsn, protected := viper.GetString("dir"), viper.GetString("dsn"), viper.GetBool("protected")
+		for k := range test.env {
+			os.Unsetenv(k)
+		}
+
+		if test.wantFail {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if dir != test.wantDir {
+			t.Errorf("%s: incorrect dir. got %q, want %q", test.name, dir, test.wantDir)
+		}
+		if dsn != test.wantDSN {
+			t.Errorf("%s: incorrect dsn. got %q, want %q", test.name, dsn, test.wantDSN)
+		}
+		if protected != test.wantProtected {
+			t.Errorf("%s: incorrect protected. got %v, want %v", test.name, protected, test.wantProtected)
+		}
+		if mig.LockTimeout != test.wantTimeout {
+			t.Errorf("%s: incorrect lock timeout. got %v, want %v", test.name, mig.LockTimeout, test.wantTimeout)
+		}
+	}
+}
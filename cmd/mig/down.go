@@ -28,7 +28,11 @@ var downAllCmd = &cobra.Command{
 
 func init() {
 	downCmd.Flags().StringP("dir", "d", ".", "directory with migration files")
+	downCmd.Flags().String("dump-schema", "", "file to dump the resulting schema to")
 	downAllCmd.Flags().StringP("dir", "d", ".", "directory with migration files")
+	downAllCmd.Flags().String("dump-schema", "", "file to dump the resulting schema to")
+	addConfirmFlag(downCmd)
+	addConfirmFlag(downAllCmd)
 
 	rootCmd.AddCommand(downCmd)
 	rootCmd.AddCommand(downAllCmd)
@@ -47,16 +51,23 @@ func downRunE(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	err = confirmProtected(conn, "roll back", func() ([]string, error) {
+		return rollbackPlan(conn, viper.GetString("dir"), false)
+	})
+	if err != nil {
+		return err
+	}
+
 	name, err := mig.Down(conn, viper.GetString("dir"))
 	if mig.IsNoMigrationError(err) {
 		fmt.Println("No migrations to run")
-		return nil
+		return dumpSchemaIfRequested(conn)
 	} else if err != nil {
 		return err
 	}
 
 	fmt.Printf("Success   %v\n", name)
-	return nil
+	return dumpSchemaIfRequested(conn)
 }
 
 func downAllRunE(cmd *cobra.Command, args []string) error {
@@ -65,6 +76,13 @@ func downAllRunE(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	err = confirmProtected(conn, "roll back", func() ([]string, error) {
+		return rollbackPlan(conn, viper.GetString("dir"), true)
+	})
+	if err != nil {
+		return err
+	}
+
 	count, err := mig.DownAll(conn, viper.GetString("dir"))
 	if err != nil {
 		return err
@@ -76,5 +94,5 @@ func downAllRunE(cmd *cobra.Command, args []string) error {
 		fmt.Printf("Success   %d migrations\n", count)
 	}
 
-	return nil
+	return dumpSchemaIfRequested(conn)
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/satriahrh/mig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "Report differences between the database and the expected schema",
+	Long: `Report differences between the database and the expected schema,
+either a schema dump or the schema of a second database.
+Exits non-zero when drift is detected.`,
+	Example: `$ mig drift "user:password@tcp(localhost:5555)/dbname" --schema schema.sql
+$ mig drift "user:password@tcp(localhost:5555)/dbname" --against "user:password@tcp(localhost:5556)/dbname"`,
+	RunE: driftRunE,
+}
+
+func init() {
+	driftCmd.Flags().String("schema", "", "schema dump to compare the database against")
+	driftCmd.Flags().String("against", "", "connection string of a database to compare the database against")
+
+	rootCmd.AddCommand(driftCmd)
+	driftCmd.PreRun = func(*cobra.Command, []string) {
+		viper.BindPFlags(driftCmd.Flags())
+	}
+}
+
+func driftRunE(cmd *cobra.Command, args []string) error {
+	conn, err := getConnArgs(args)
+	if err != nil {
+		return err
+	}
+
+	schema, against := viper.GetString("schema"), viper.GetString("against")
+
+	var diffs []mig.SchemaDifference
+	switch {
+	case len(schema) > 0 && len(against) > 0:
+		return errors.New("only one of --schema and --against can be provided")
+	case len(schema) > 0:
+		f, err := os.Open(schema)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		diffs, err = mig.Drift(conn, f)
+		if err != nil {
+			return err
+		}
+	case len(against) > 0:
+		addDSNSecret(against)
+		diffs, err = mig.DriftBetween(conn, against)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("no expected schema provided, use --schema or --against")
+	}
+
+	if len(diffs) == 0 {
+		fmt.Println("No drift detected")
+		return nil
+	}
+
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+
+	return fmt.Errorf("schema drift detected: %d differences", len(diffs))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/satriahrh/mig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var dumpCmd = &cobra.Command{
+	Use:     "dump",
+	Short:   "Dump the schema of the database",
+	Long:    "Dump the schema of the database, including the applied migration versions",
+	Example: `$ mig dump "user:password@tcp(localhost:5555)/dbname?tls=skip-verify&autocommit=true" -o schema.sql`,
+	RunE:    dumpRunE,
+}
+
+func init() {
+	dumpCmd.Flags().StringP("output", "o", "", "file to write the schema to instead of stdout")
+
+	rootCmd.AddCommand(dumpCmd)
+	dumpCmd.PreRun = func(*cobra.Command, []string) {
+		viper.BindPFlags(dumpCmd.Flags())
+	}
+}
+
+func dumpRunE(cmd *cobra.Command, args []string) error {
+	conn, err := getConnArgs(args)
+	if err != nil {
+		return err
+	}
+
+	path := viper.GetString("output")
+	if len(path) == 0 {
+		return mig.DumpSchema(conn, os.Stdout)
+	}
+
+	return writeSchemaDump(conn, path)
+}
+
+// writeSchemaDump dumps the schema of the database to the file at path,
+// leaving the file untouched if the dump fails.
+func writeSchemaDump(conn, path string) error {
+	var buf bytes.Buffer
+	if err := mig.DumpSchema(conn, &buf); err != nil {
+		return fmt.Errorf("error dumping schema: %v", err)
+	}
+
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// dumpSchemaIfRequested dumps the schema of the database to the path
+// given with the --dump-schema flag, if any.
+func dumpSchemaIfRequested(conn string) error {
+	path := viper.GetString("dump-schema")
+	if len(path) == 0 {
+		return nil
+	}
+
+	if err := writeSchemaDump(conn, path); err != nil {
+		return err
+	}
+
+	fmt.Printf("Dumped schema to %s\n", path)
+	return nil
+}
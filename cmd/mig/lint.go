@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/satriahrh/mig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [dir]",
+	Short: "Check migration files for destructive or locking statements",
+	Long: `Check migration files for destructive or locking statements.
+Exits non-zero when an issue of severity error is found.`,
+	Example: `$ mig lint migrations
+$ mig lint -d migrations --rule idempotent=warning --rule mixed-ddl-dml=off --format sarif`,
+	Args:         cobra.MaximumNArgs(1),
+	RunE:         lintRunE,
+	SilenceUsage: true,
+}
+
+func init() {
+	lintCmd.Flags().StringP("dir", "d", ".", "directory with migration files")
+	lintCmd.Flags().StringArray("rule", nil, "override the severity of a rule as name=error|warning|info|off, may be repeated")
+	lintCmd.Flags().String("format", "text", "output format, text, json or sarif")
+
+	rootCmd.AddCommand(lintCmd)
+	lintCmd.PreRun = func(*cobra.Command, []string) {
+		viper.BindPFlags(lintCmd.Flags())
+	}
+
+	var rules []string
+	for _, rule := range mig.LintRules() {
+		rules = append(rules, fmt.Sprintf("  %-18s %-8s %s", rule.Name, rule.Severity, rule.Description))
+	}
+	lintCmd.Long += "\n\nRules:\n" + strings.Join(rules, "\n")
+}
+
+func lintRunE(cmd *cobra.Command, args []string) error {
+	severities := map[string]mig.Severity{}
+	rules, err := cmd.Flags().GetStringArray("rule")
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		kv := strings.SplitN(rule, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid rule %q, expected name=severity", rule)
+		}
+		severities[kv[0]] = mig.Severity(kv[1])
+	}
+
+	issues, err := mig.Lint(getDirArgs(args), severities)
+	if err != nil {
+		return err
+	}
+
+	switch viper.GetString("format") {
+	case "text":
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+	case "json":
+		if issues == nil {
+			issues = []mig.LintIssue{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(issues); err != nil {
+			return err
+		}
+	case "sarif":
+		if err := writeSARIF(os.Stdout, issues); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q", viper.GetString("format"))
+	}
+
+	for _, issue := range issues {
+		if issue.Severity == mig.SeverityError {
+			return errors.New("lint errors found")
+		}
+	}
+
+	return nil
+}
+
+// sarifLevels maps lint severities to SARIF result levels
+var sarifLevels = map[mig.Severity]string{
+	mig.SeverityError:   "error",
+	mig.SeverityWarning: "warning",
+	mig.SeverityInfo:    "note",
+}
+
+// writeSARIF writes lint issues as a SARIF 2.1.0 log,
+// as understood by code scanning tools.
+func writeSARIF(w io.Writer, issues []mig.LintIssue) error {
+	type message struct {
+		Text string `json:"text"`
+	}
+	type rule struct {
+		ID               string  `json:"id"`
+		ShortDescription message `json:"shortDescription"`
+	}
+	type location struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+			Region struct {
+				StartLine int `json:"startLine"`
+			} `json:"region"`
+		} `json:"physicalLocation"`
+	}
+	type result struct {
+		RuleID    string     `json:"ruleId"`
+		Level     string     `json:"level"`
+		Message   message    `json:"message"`
+		Locations []location `json:"locations"`
+	}
+
+	rules := []rule{}
+	for _, r := range mig.LintRules() {
+		rules = append(rules, rule{ID: r.Name, ShortDescription: message{Text: r.Description}})
+	}
+
+	results := []result{}
+	for _, issue := range issues {
+		var l location
+		l.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(issue.File)
+		l.PhysicalLocation.Region.StartLine = issue.Line
+
+		results = append(results, result{
+			RuleID:    issue.Rule,
+			Level:     sarifLevels[issue.Severity],
+			Message:   message{Text: issue.Message},
+			Locations: []location{l},
+		})
+	}
+
+	log := map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []interface{}{
+			map[string]interface{}{
+				"tool": map[string]interface{}{
+					"driver": map[string]interface{}{
+						"name":           "mig",
+						"informationUri": "https://github.com/satriahrh/mig",
+						"rules":          rules,
+					},
+				},
+				"results": results,
+			},
+		},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
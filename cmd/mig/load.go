@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/satriahrh/mig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var loadCmd = &cobra.Command{
+	Use:     "load",
+	Short:   "Load a schema dump into an empty database",
+	Long:    "Load a schema dump, including its migration versions, into an empty database",
+	Example: `$ mig load "user:password@tcp(localhost:5555)/dbname" schema.sql`,
+	RunE:    loadRunE,
+}
+
+func init() {
+	rootCmd.AddCommand(loadCmd)
+	loadCmd.PreRun = func(*cobra.Command, []string) {
+		viper.BindPFlags(loadCmd.Flags())
+	}
+}
+
+func loadRunE(cmd *cobra.Command, args []string) error {
+	// the schema dump is the last argument, the connection string
+	// may come from the config file instead
+	if len(args) < 1 || len(args[len(args)-1]) == 0 {
+		return errors.New("no schema dump provided")
+	}
+	path := args[len(args)-1]
+
+	conn, err := getConnArgs(args[:len(args)-1])
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	version, err := mig.LoadSchema(conn, f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Loaded    %s at version %d\n", path, version)
+	return nil
+}
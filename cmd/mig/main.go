@@ -1,12 +1,23 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
+
+	"github.com/satriahrh/mig"
 )
 
 const migVersion = "1.0.0"
 
+// stdin is shared by everything reading answers from the operator,
+// so that none of them buffers away input meant for another
+var stdin = bufio.NewReader(os.Stdin)
+
 func main() {
 	// Too much happens between here and cobra's argument handling, for
 	// something so simple. Just do it immediately.
@@ -16,7 +27,43 @@ func main() {
 	}
 
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		printError(os.Stderr, err, isTerminal(os.Stderr))
 		os.Exit(1)
 	}
 }
+
+// printError prints err and, for a failed migration, the offending
+// statement with its line numbers in the migration file. The lines at
+// fault are marked with '>', in red when color is set. Passwords are masked.
+func printError(w io.Writer, err error, color bool) {
+	fmt.Fprintln(w, redact(err.Error()))
+
+	var me *mig.MigrationError
+	if !errors.As(err, &me) || len(me.Statement) == 0 {
+		return
+	}
+
+	lines := strings.Split(me.Statement, "\n")
+	width := len(strconv.Itoa(me.Line + len(lines) - 1))
+
+	fmt.Fprintf(w, "\n%s, statement %d:\n", me.File, me.StatementIndex+1)
+	for i, line := range lines {
+		// without a line reported, the whole statement is at fault
+		if me.ErrorLine > 0 && me.Line+i != me.ErrorLine {
+			fmt.Fprintf(w, "  %*d | %s\n", width, me.Line+i, line)
+			continue
+		}
+
+		l := fmt.Sprintf("> %*d | %s", width, me.Line+i, line)
+		if color {
+			l = "\x1b[31m" + l + "\x1b[0m"
+		}
+		fmt.Fprintln(w, l)
+	}
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/satriahrh/mig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// addConfirmFlag adds the flag confirming a destructive command
+// in automation to cmd.
+func addConfirmFlag(cmd *cobra.Command) {
+	cmd.Flags().String("yes-i-am-sure", "", "name of the database, confirming the command against a protected environment without a prompt")
+}
+
+// rollbackPlan returns the applied migrations of dir in the order they
+// would be rolled back, only the latest one unless all is set.
+func rollbackPlan(conn, dir string, all bool) ([]string, error) {
+	status, err := mig.Status(conn, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan []string
+	for i := len(status) - 1; i >= 0; i-- {
+		if status[i].Applied == "Pending" || status[i].Orphaned {
+			continue
+		}
+
+		plan = append(plan, status[i].Name)
+		if !all {
+			break
+		}
+	}
+
+	return plan, nil
+}
+
+// pendingPlan returns the pending migrations of dir in the order they
+// would be applied.
+func pendingPlan(conn, dir string) ([]string, error) {
+	status, err := mig.Status(conn, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan []string
+	for _, s := range status {
+		if s.Applied == "Pending" {
+			plan = append(plan, s.Name)
+		}
+	}
+
+	return plan, nil
+}
+
+// confirmProtected asks for the name of the database to be typed before
+// a destructive command runs against an environment with protected set,
+// after printing what the command is about to do. In automation the name
+// is given with --yes-i-am-sure instead.
+func confirmProtected(conn, action string, plan func() ([]string, error)) error {
+	if !viper.GetBool("protected") {
+		return nil
+	}
+
+	cfg, err := mysql.ParseDSN(conn)
+	if err != nil {
+		return err
+	}
+
+	steps, err := plan()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database %s is protected, about to %s:\n", cfg.DBName, action)
+	if len(steps) == 0 {
+		fmt.Println("  nothing")
+	}
+	for _, step := range steps {
+		fmt.Printf("  %s\n", step)
+	}
+
+	if sure := viper.GetString("yes-i-am-sure"); len(sure) > 0 {
+		if sure != cfg.DBName {
+			return fmt.Errorf("--yes-i-am-sure=%s does not match database %s", sure, cfg.DBName)
+		}
+		return nil
+	}
+
+	errNoConfirmation := errors.New("refusing to run against a protected database without confirmation, use --yes-i-am-sure=<dbname>")
+	if !isTerminal(os.Stdin) {
+		return errNoConfirmation
+	}
+
+	fmt.Print("Type the name of the database to continue: ")
+	line, err := stdin.ReadString('\n')
+	if err == io.EOF {
+		return errNoConfirmation
+	} else if err != nil {
+		return err
+	}
+	if strings.TrimSpace(line) != cfg.DBName {
+		return errors.New("confirmation does not match the database name, aborting")
+	}
+
+	return nil
+}
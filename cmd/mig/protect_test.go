@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestConfirmProtected(t *testing.T) {
+
+	conn := "user:password@tcp(localhost:3306)/app"
+	plan := func() ([]string, error) { return []string{"POST /up", "POST /down"}, nil }
+
+	tests := []struct {
+		protected bool
+		sure      string
+		wantFail  bool
+	}{
+		{false, "", false},
+		{true, "app", false},
+		{true, "other", true},
+	}
+
+	for _, test := range tests {
+		viper.Reset()
+		viper.Set("protected", test.protected)
+		viper.Set("yes-i-am-sure", test.sure)
+
+		if err := confirmProtected(conn, "serve", plan); (err != nil) != test.wantFail {
+			t.Errorf("protected %v, confirmed %q: incorrect error %v", test.protected, test.sure, err)
+		}
+	}
+	viper.Reset()
+}
@@ -18,7 +18,7 @@ var redoCmd = &cobra.Command{
 }
 
 var redoAllCmd = &cobra.Command{
-	Use:   "redo",
+	Use:   "redoall",
 	Short: "Down then up all migrations",
 	Long:  "Down then up all migrations",
 	Example: `$ mig redoall "user:password@tcp(localhost:5555)/dbname?tls=skip-verify&autocommit=true
@@ -28,11 +28,19 @@ var redoAllCmd = &cobra.Command{
 
 func init() {
 	redoCmd.Flags().StringP("dir", "d", ".", "directory with migration files")
+	redoAllCmd.Flags().StringP("dir", "d", ".", "directory with migration files")
+	addConfirmFlag(redoCmd)
+	addConfirmFlag(redoAllCmd)
 
 	rootCmd.AddCommand(redoCmd)
+	rootCmd.AddCommand(redoAllCmd)
+
 	redoCmd.PreRun = func(*cobra.Command, []string) {
 		viper.BindPFlags(redoCmd.Flags())
 	}
+	redoAllCmd.PreRun = func(*cobra.Command, []string) {
+		viper.BindPFlags(redoAllCmd.Flags())
+	}
 }
 
 func redoRunE(cmd *cobra.Command, args []string) error {
@@ -41,6 +49,13 @@ func redoRunE(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	err = confirmProtected(conn, "roll back and reapply", func() ([]string, error) {
+		return rollbackPlan(conn, viper.GetString("dir"), false)
+	})
+	if err != nil {
+		return err
+	}
+
 	name, err := mig.Redo(conn, viper.GetString("dir"))
 	if mig.IsNoMigrationError(err) {
 		fmt.Println("No migrations to run")
@@ -59,6 +74,13 @@ func redoAllRunE(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	err = confirmProtected(conn, "roll back, then reapply every migration", func() ([]string, error) {
+		return rollbackPlan(conn, viper.GetString("dir"), true)
+	})
+	if err != nil {
+		return err
+	}
+
 	_, err = mig.DownAll(conn, viper.GetString("dir"))
 	if err != nil {
 		return err
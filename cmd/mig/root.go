@@ -2,8 +2,10 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"math"
 	"os"
+	"strings"
 
 	"github.com/satriahrh/mig"
 	"github.com/spf13/cobra"
@@ -22,24 +24,177 @@ var rootCmd = &cobra.Command{
 	Example: `$ mig up user:password@tcp(localhost:5555)/dbname?tls=skip-verify&autocommit=true
 $ mig down "user:password@tcp(localhost:5555)/dbname?tls=skip-verify&autocommit=true"
 $ mig create add_users`,
+	// errors are printed by main, with passwords masked
+	SilenceErrors: true,
 }
 
 func init() {
-	// Set the mig library logger to os.Stdout
-	mig.Log = os.Stdout
+	// Set the mig library logger to os.Stdout, masking passwords
+	mig.Log = redactingWriter{os.Stdout}
 
 	rootCmd.Flags().BoolP("version", "", false, "Print the mig tool version")
 	viper.BindPFlags(rootCmd.Flags())
+
+	rootCmd.PersistentFlags().String("config", "", "config file (default mig.yaml or mig.toml in the working directory)")
+	rootCmd.PersistentFlags().String("env", "", "environment of the config file to use, such as dev or prod")
+	rootCmd.PersistentFlags().String("password-file", "", "file to read the password from, instead of the connection string")
+	rootCmd.PersistentFlags().Bool("password-stdin", false, "read the password from stdin, instead of the connection string")
+	rootCmd.PersistentFlags().String("defaults-file", "", "MySQL option file with a [client] section completing the connection string (default ~/.my.cnf)")
+	rootCmd.PersistentFlags().String("table", mig.VersionTable, "table recording the applied versions")
+	rootCmd.PersistentFlags().Bool("ignore-unknown", false, "migrate although versions applied to the database have no migration file")
+	rootCmd.PersistentFlags().Duration("lock-timeout", mig.LockTimeout, "longest wait for the migration lock held by another mig process")
+
+	rootCmd.PersistentFlags().String("osc-tool", mig.GhOst, "online schema change tool, gh-ost or pt-online-schema-change")
+	rootCmd.PersistentFlags().String("osc-path", "", "path to the online schema change tool (default looked up in PATH)")
+	rootCmd.PersistentFlags().StringArray("osc-arg", nil, "extra flag passed to the online schema change tool, may be repeated")
+
+	rootCmd.PersistentFlags().StringArray("replica", nil, "connection string of a replica to throttle on, may be repeated")
+	rootCmd.PersistentFlags().String("heartbeat-table", "", "heartbeat table to measure replication lag with, instead of SHOW REPLICA STATUS")
+	rootCmd.PersistentFlags().Duration("max-lag", mig.Throttle.MaxLag, "replication lag above which migrations pause")
+	rootCmd.PersistentFlags().Duration("max-lag-wait", mig.Throttle.MaxWait, "longest pause for replication lag before aborting")
+
+	rootCmd.PersistentFlags().Int("retry-attempts", mig.Retry.MaxAttempts, "attempts of a migration failing with a deadlock, lock wait timeout or lost connection")
+	rootCmd.PersistentFlags().Duration("retry-backoff", mig.Retry.MinBackoff, "wait before retrying a failed migration, doubled on every retry")
+	rootCmd.PersistentFlags().Duration("retry-max-backoff", mig.Retry.MaxBackoff, "longest wait between retries of a failed migration")
+
+	rootCmd.PersistentFlags().StringArray("set", nil, "session variable name=value set for every migration, may be repeated")
+
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd, args); err != nil {
+			return err
+		}
+		if err := configureOnlineSchemaChange(cmd, args); err != nil {
+			return err
+		}
+		if err := configureThrottle(cmd, args); err != nil {
+			return err
+		}
+		if err := configureRetry(cmd, args); err != nil {
+			return err
+		}
+		return configureSessionVariables(cmd, args)
+	}
 }
 
-// getConnArgs takes in args from cobra and returns the 0th and 1st arg
-// which should be the driver and connection string
+// configureOnlineSchemaChange configures the tool statements annotated with
+// '-- +mig OnlineSchemaChange' are handed to.
+func configureOnlineSchemaChange(cmd *cobra.Command, args []string) error {
+	var err error
+	config := mig.OnlineSchemaChangeConfig{}
+
+	if config.Tool, err = cmd.Flags().GetString("osc-tool"); err != nil {
+		return err
+	}
+	if config.Path, err = cmd.Flags().GetString("osc-path"); err != nil {
+		return err
+	}
+	if config.Args, err = cmd.Flags().GetStringArray("osc-arg"); err != nil {
+		return err
+	}
+
+	mig.OnlineSchemaChange = config
+	return nil
+}
+
+// getConnArgs takes in args from cobra and returns the 0th arg
+// which should be the connection string, falling back to the dsn
+// of the config file or the MIG_DSN environment variable. The
+// connection string is completed by resolveConn.
 func getConnArgs(args []string) (conn string, err error) {
 	if len(args) < 1 {
-		err = errors.New("no connection details provided")
+		conn = viper.GetString("dsn")
+		if len(conn) == 0 {
+			err = errors.New("no connection details provided")
+			return
+		}
+	} else {
+		conn = args[0]
+	}
+	addDSNSecret(conn)
+
+	if conn, err = resolveConn(conn); err != nil {
 		return
 	}
-	conn = args[0]
 
+	// online schema change tools connect to the migrated database as well
+	mig.OnlineSchemaChange.DSN = conn
 	return
 }
+
+// getDirArgs takes in args from cobra and returns the 0th arg as the
+// directory with migration files, falling back to --dir, for the commands
+// working on migration files only.
+func getDirArgs(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+
+	return viper.GetString("dir")
+}
+
+// configureThrottle configures the pausing of migrations while
+// replicas lag behind.
+func configureThrottle(cmd *cobra.Command, args []string) error {
+	var err error
+	config := mig.Throttle
+
+	if config.Replicas, err = cmd.Flags().GetStringArray("replica"); err != nil {
+		return err
+	}
+	for _, replica := range config.Replicas {
+		addDSNSecret(replica)
+	}
+	if config.HeartbeatTable, err = cmd.Flags().GetString("heartbeat-table"); err != nil {
+		return err
+	}
+	if config.MaxLag, err = cmd.Flags().GetDuration("max-lag"); err != nil {
+		return err
+	}
+	if config.MaxWait, err = cmd.Flags().GetDuration("max-lag-wait"); err != nil {
+		return err
+	}
+
+	mig.Throttle = config
+	return nil
+}
+
+// configureRetry configures the retrying of migrations failing
+// with transient errors.
+func configureRetry(cmd *cobra.Command, args []string) error {
+	var err error
+	config := mig.Retry
+
+	if config.MaxAttempts, err = cmd.Flags().GetInt("retry-attempts"); err != nil {
+		return err
+	}
+	if config.MinBackoff, err = cmd.Flags().GetDuration("retry-backoff"); err != nil {
+		return err
+	}
+	if config.MaxBackoff, err = cmd.Flags().GetDuration("retry-max-backoff"); err != nil {
+		return err
+	}
+
+	mig.Retry = config
+	return nil
+}
+
+// configureSessionVariables configures the session variables set
+// for every migration.
+func configureSessionVariables(cmd *cobra.Command, args []string) error {
+	settings, err := cmd.Flags().GetStringArray("set")
+	if err != nil {
+		return err
+	}
+
+	vars := map[string]string{}
+	for _, setting := range settings {
+		i := strings.Index(setting, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid --set %q, expected name=value", setting)
+		}
+		vars[strings.TrimSpace(setting[:i])] = strings.TrimSpace(setting[i+1:])
+	}
+
+	mig.SessionVariables = vars
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/satriahrh/mig"
+	"github.com/spf13/viper"
+)
+
+var (
+	secretsMu sync.Mutex
+	secrets   []string
+)
+
+// addSecret registers a password to be masked in everything mig prints.
+func addSecret(secret string) {
+	if len(secret) == 0 {
+		return
+	}
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets = append(secrets, secret)
+}
+
+// addDSNSecret registers the password of a connection string.
+func addDSNSecret(dsn string) {
+	if cfg, err := mysql.ParseDSN(dsn); err == nil {
+		addSecret(cfg.Passwd)
+	}
+}
+
+// redact masks the registered passwords in s.
+func redact(s string) string {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	for _, secret := range secrets {
+		s = strings.Replace(s, secret, "xxxxx", -1)
+	}
+
+	return s
+}
+
+// redactingWriter masks the registered passwords in everything written to w.
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redact(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// readOptionFile reads the [client] section of a MySQL option file,
+// such as ~/.my.cnf.
+func readOptionFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	options := map[string]string{}
+	section := ""
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) == 0, line[0] == '#', line[0] == ';', line[0] == '!':
+			continue
+		case line[0] == '[':
+			section = strings.TrimSpace(strings.Trim(line, "[]"))
+			continue
+		case section != "client":
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		key := strings.Replace(strings.TrimSpace(kv[0]), "_", "-", -1)
+		value := ""
+		if len(kv) == 2 {
+			value = strings.TrimSpace(kv[1])
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+				value = value[1 : len(value)-1]
+			}
+		}
+		options[key] = value
+	}
+
+	return options, scanner.Err()
+}
+
+// readPassword reads the password from --password-file, --password-stdin
+// or MIG_PASSWORD, returning false if none of them is set.
+func readPassword() (string, bool, error) {
+	switch {
+	case len(viper.GetString("password-file")) > 0:
+		b, err := ioutil.ReadFile(viper.GetString("password-file"))
+		if err != nil {
+			return "", false, fmt.Errorf("error reading password file: %v", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), true, nil
+	case viper.GetBool("password-stdin"):
+		line, err := stdin.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", false, fmt.Errorf("error reading password from stdin: %v", err)
+		}
+		return strings.TrimRight(line, "\r\n"), true, nil
+	case viper.IsSet("password"):
+		return viper.GetString("password"), true, nil
+	}
+
+	return "", false, nil
+}
+
+// resolveConn completes a connection string with the [client] section of
+// the MySQL option file and the password given out of band, and registers
+// the password to be masked. Values in the connection string take
+// precedence over the option file, the password given out of band takes
+// precedence over both.
+func resolveConn(conn string) (string, error) {
+	cfg, err := mysql.ParseDSN(conn)
+	if err != nil {
+		return "", fmt.Errorf("invalid connection string %s: %v", mig.RedactDSN(conn), err)
+	}
+
+	path := viper.GetString("defaults-file")
+	if len(path) == 0 {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, ".my.cnf")
+		}
+	}
+	options, err := readOptionFile(path)
+	if err != nil && (!errors.Is(err, os.ErrNotExist) || len(viper.GetString("defaults-file")) > 0) {
+		return "", fmt.Errorf("error reading option file: %v", err)
+	}
+
+	if len(cfg.User) == 0 {
+		cfg.User = options["user"]
+	}
+	if len(cfg.Passwd) == 0 {
+		cfg.Passwd = options["password"]
+	}
+	if len(cfg.DBName) == 0 {
+		cfg.DBName = options["database"]
+	}
+	// the driver defaults to tcp(127.0.0.1:3306) when the address is missing
+	if !strings.Contains(conn, "(") {
+		switch {
+		case len(options["socket"]) > 0:
+			cfg.Net, cfg.Addr = "unix", options["socket"]
+		case len(options["host"]) > 0 || len(options["port"]) > 0:
+			host, port := options["host"], options["port"]
+			if len(host) == 0 {
+				host = "127.0.0.1"
+			}
+			if len(port) == 0 {
+				port = "3306"
+			}
+			cfg.Net, cfg.Addr = "tcp", host+":"+port
+		}
+	}
+
+	password, ok, err := readPassword()
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		cfg.Passwd = password
+	}
+
+	addSecret(cfg.Passwd)
+	return cfg.FormatDSN(), nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/spf13/viper"
+)
+
+func TestReadOptionFile(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "my.cnf")
+	content := `# comment
+[mysqld]
+user = server
+
+[client]
+user = app
+password = "p#ss word"
+; comment
+host='db.internal'
+ssl_mode = REQUIRED
+skip-ssl
+!includedir /etc/mysql/conf.d/
+
+[mysqldump]
+password = other
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	options, err := readOptionFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"user":     "app",
+		"password": "p#ss word",
+		"host":     "db.internal",
+		"ssl-mode": "REQUIRED",
+		"skip-ssl": "",
+	}
+	if !reflect.DeepEqual(options, want) {
+		t.Errorf("incorrect options.\ngot:  %v\nwant: %v", options, want)
+	}
+}
+
+func TestResolveConn(t *testing.T) {
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tcpOptions := write("tcp.cnf", "[client]\nuser=optuser\npassword=optpass\nhost=db.internal\nport=3307\ndatabase=optdb\n")
+	socketOptions := write("socket.cnf", "[client]\nsocket=/run/mysqld/mysqld.sock\n")
+	passwordFile := write("password", "filepass\n")
+
+	// no ~/.my.cnf unless given with --defaults-file
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", dir)
+
+	tests := []struct {
+		name     string
+		conn     string
+		flags    map[string]string
+		env      string // MIG_PASSWORD
+		want     mysql.Config
+		wantFail bool
+	}{
+		{
+			name: "connection string only",
+			conn: "user:pass@tcp(localhost:3306)/app",
+			want: mysql.Config{User: "user", Passwd: "pass", Net: "tcp", Addr: "localhost:3306", DBName: "app"},
+		},
+		{
+			name:  "connection string over option file",
+			conn:  "user:pass@tcp(localhost:3306)/app",
+			flags: map[string]string{"defaults-file": tcpOptions},
+			want:  mysql.Config{User: "user", Passwd: "pass", Net: "tcp", Addr: "localhost:3306", DBName: "app"},
+		},
+		{
+			name:  "option file completes the connection string",
+			conn:  "/",
+			flags: map[string]string{"defaults-file": tcpOptions},
+			want:  mysql.Config{User: "optuser", Passwd: "optpass", Net: "tcp", Addr: "db.internal:3307", DBName: "optdb"},
+		},
+		{
+			name:  "socket of the option file",
+			conn:  "user@/app",
+			flags: map[string]string{"defaults-file": socketOptions},
+			want:  mysql.Config{User: "user", Net: "unix", Addr: "/run/mysqld/mysqld.sock", DBName: "app"},
+		},
+		{
+			name:  "address of the connection string over socket",
+			conn:  "user@tcp(localhost:3306)/app",
+			flags: map[string]string{"defaults-file": socketOptions},
+			want:  mysql.Config{User: "user", Net: "tcp", Addr: "localhost:3306", DBName: "app"},
+		},
+		{
+			name: "MIG_PASSWORD over connection string",
+			conn: "user:pass@tcp(localhost:3306)/app",
+			env:  "envpass",
+			want: mysql.Config{User: "user", Passwd: "envpass", Net: "tcp", Addr: "localhost:3306", DBName: "app"},
+		},
+		{
+			name:  "MIG_PASSWORD over option file",
+			conn:  "/",
+			flags: map[string]string{"defaults-file": tcpOptions},
+			env:   "envpass",
+			want:  mysql.Config{User: "optuser", Passwd: "envpass", Net: "tcp", Addr: "db.internal:3307", DBName: "optdb"},
+		},
+		{
+			name:  "password file over MIG_PASSWORD",
+			conn:  "user:pass@tcp(localhost:3306)/app",
+			flags: map[string]string{"password-file": passwordFile},
+			env:   "envpass",
+			want:  mysql.Config{User: "user", Passwd: "filepass", Net: "tcp", Addr: "localhost:3306", DBName: "app"},
+		},
+		{
+			name:     "missing password file",
+			conn:     "user@tcp(localhost:3306)/app",
+			flags:    map[string]string{"password-file": filepath.Join(dir, "missing")},
+			wantFail: true,
+		},
+		{
+			name:     "missing option file given explicitly",
+			conn:     "user@tcp(localhost:3306)/app",
+			flags:    map[string]string{"defaults-file": filepath.Join(dir, "missing.cnf")},
+			wantFail: true,
+		},
+		{
+			name:     "invalid connection string",
+			conn:     "user:pass@tcp(localhost:3306",
+			wantFail: true,
+		},
+	}
+
+	defer func() { secrets = nil }()
+
+	for _, test := range tests {
+		viper.Reset()
+		secrets = nil
+		viper.SetEnvPrefix("mig")
+		viper.AutomaticEnv()
+		for k, v := range test.flags {
+			viper.Set(k, v)
+		}
+		if len(test.env) > 0 {
+			os.Setenv("MIG_PASSWORD", test.env)
+		}
+
+		conn, err := resolveConn(test.conn)
+		os.Unsetenv("MIG_PASSWORD")
+
+		if test.wantFail {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", test.name, conn)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		cfg, err := mysql.ParseDSN(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := mysql.Config{User: cfg.User, Passwd: cfg.Passwd, Net: cfg.Net, Addr: cfg.Addr, DBName: cfg.DBName}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: incorrect connection.\ngot:  %+v\nwant: %+v", test.name, got, test.want)
+		}
+		if len(cfg.Passwd) > 0 && redact(cfg.Passwd) != "xxxxx" {
+			t.Errorf("%s: password not masked", test.name)
+		}
+	}
+	viper.Reset()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/satriahrh/mig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the migration state of the database over HTTP",
+	Long: `Serve the migration state of the database as JSON on /status, /version,
+/pending, /history and /ready. With a token, POST /up and /down migrate the database
+up or down to the version parameter, authenticated with the token as a bearer
+token. Pass the token with MIG_TOKEN to keep it out of the shell history.`,
+	Example: `$ mig serve "user:password@tcp(localhost:5555)/dbname" -d migrations --addr :8080
+$ curl localhost:8080/status
+$ MIG_TOKEN=secret mig serve "user:password@tcp(localhost:5555)/dbname" -d migrations
+$ curl -X POST -H "Authorization: Bearer secret" localhost:8080/up?version=20170314221501`,
+	RunE: serveRunE,
+}
+
+func init() {
+	serveCmd.Flags().StringP("dir", "d", ".", "directory with migration files")
+	serveCmd.Flags().String("addr", ":8080", "address to listen on")
+	serveCmd.Flags().String("token", "", "bearer token enabling POST /up and /down")
+	addConfirmFlag(serveCmd)
+
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.PreRun = func(*cobra.Command, []string) {
+		viper.BindPFlags(serveCmd.Flags())
+	}
+}
+
+func serveRunE(cmd *cobra.Command, args []string) error {
+	conn, err := getConnArgs(args)
+	if err != nil {
+		return err
+	}
+
+	db, err := mig.Open(conn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := mig.SetDialect(); err != nil {
+		return err
+	}
+
+	// POST /up and /down migrate the database without a prompt,
+	// so confirm enabling them once before serving
+	if len(viper.GetString("token")) > 0 {
+		err = confirmProtected(conn, "serve POST /up and /down, migrating it on request", func() ([]string, error) {
+			return []string{"POST /up", "POST /down"}, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	addSecret(viper.GetString("token"))
+	handler := mig.NewHandler(db, viper.GetString("dir"), viper.GetString("token"))
+
+	fmt.Printf("Serving   on %s\n", viper.GetString("addr"))
+	return http.ListenAndServe(viper.GetString("addr"), handler)
+}
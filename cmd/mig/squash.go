@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/satriahrh/mig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var squashCmd = &cobra.Command{
+	Use:   "squash",
+	Short: "Squash old migrations into a single baseline migration",
+	Long: `Squash the migrations below a version into a single baseline migration.
+The migrations are applied to an empty scratch database whose resulting schema
+becomes the new migration, numbered after the last squashed migration.
+Databases already past that version are unaffected.`,
+	Example: `$ mig squash "user:password@tcp(localhost:5555)/scratch" --before 20180101000000 -d migrations`,
+	RunE:    squashRunE,
+}
+
+func init() {
+	squashCmd.Flags().StringP("dir", "d", ".", "directory with migration files")
+	squashCmd.Flags().Int64("before", 0, "squash migrations with a version below this one")
+	squashCmd.Flags().String("archive-dir", "", "directory to move squashed migrations to (default <dir>/archive)")
+	addConfirmFlag(squashCmd)
+
+	rootCmd.AddCommand(squashCmd)
+	squashCmd.PreRun = func(*cobra.Command, []string) {
+		viper.BindPFlags(squashCmd.Flags())
+	}
+}
+
+func squashRunE(cmd *cobra.Command, args []string) error {
+	conn, err := getConnArgs(args)
+	if err != nil {
+		return err
+	}
+
+	before := viper.GetInt64("before")
+	if before <= 0 {
+		return errors.New("no version provided, use --before")
+	}
+
+	dir := viper.GetString("dir")
+	archiveDir := viper.GetString("archive-dir")
+	if len(archiveDir) == 0 {
+		archiveDir = filepath.Join(dir, "archive")
+	}
+
+	err = confirmProtected(conn, "squash, archiving to "+archiveDir, func() ([]string, error) {
+		return squashPlan(dir, before)
+	})
+	if err != nil {
+		return err
+	}
+
+	path, err := mig.Squash(conn, dir, before, archiveDir)
+	if mig.IsNoMigrationError(err) {
+		fmt.Println("No migrations to squash")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created %s\n", path)
+	return nil
+}
+
+// squashPlan returns the migration files of dir with a version below before.
+func squashPlan(dir string, before int64) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var plan []string
+	for _, file := range files {
+		v, err := strconv.ParseInt(strings.SplitN(filepath.Base(file), "_", 2)[0], 10, 64)
+		if err == nil && v < before {
+			plan = append(plan, filepath.Base(file))
+		}
+	}
+
+	return plan, nil
+}
@@ -45,6 +45,14 @@ func statusRunE(cmd *cobra.Command, args []string) error {
 	fmt.Println("Applied At                  Migration")
 	fmt.Println("===================================================")
 	for _, s := range status {
+		if s.Orphaned {
+			fmt.Printf("%-24s -- version %d, unknown to the migration source\n", s.Applied, s.Version)
+			continue
+		}
+		if s.Modified {
+			fmt.Printf("%-24s -- %v (modified since applied)\n", s.Applied, s.Name)
+			continue
+		}
 		fmt.Printf("%-24s -- %v\n", s.Applied, s.Name)
 	}
 
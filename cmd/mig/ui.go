@@ -0,0 +1,371 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/satriahrh/mig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var uiCmd = &cobra.Command{
+	Use:   "ui",
+	Short: "Browse and apply migrations interactively",
+	Long: `Browse the migrations with their state, applied time and checksum,
+preview their statements, and migrate the database up or down to a chosen
+version after confirmation, following each statement as it executes.`,
+	Example:      `$ mig ui "user:password@tcp(localhost:5555)/dbname" -d migrations`,
+	RunE:         uiRunE,
+	SilenceUsage: true,
+}
+
+func init() {
+	uiCmd.Flags().StringP("dir", "d", ".", "directory with migration files")
+
+	rootCmd.AddCommand(uiCmd)
+	uiCmd.PreRun = func(*cobra.Command, []string) {
+		viper.BindPFlags(uiCmd.Flags())
+	}
+}
+
+const uiHelp = `Commands:
+  list                    list the migrations
+  show <version> [down]   preview the Up, or Down, statements of a migration
+  goto [version]          migrate up or down to a version, 0 rolls back every migration,
+                          picking it from the list of migrations without a version
+  up                      migrate to the most recent version
+  down                    roll back the latest migration
+  help                    print this help
+  quit                    leave`
+
+func uiRunE(cmd *cobra.Command, args []string) error {
+	conn, err := getConnArgs(args)
+	if err != nil {
+		return err
+	}
+	dir := viper.GetString("dir")
+
+	db, err := mig.Open(conn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := mig.SetDialect(); err != nil {
+		return err
+	}
+
+	// follow each statement as it executes
+	mig.Verbose = true
+
+	if err := uiList(db, dir); err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Println(uiHelp)
+
+	for {
+		fmt.Print("\nmig> ")
+		line, err := stdin.ReadString('\n')
+		if err == io.EOF && len(line) == 0 {
+			fmt.Println()
+			return nil
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "list", "l":
+			err = uiList(db, dir)
+		case "show", "s":
+			err = uiShow(db, dir, fields[1:])
+		case "goto", "g":
+			var target int64
+			picked := true
+			if len(fields) < 2 {
+				target, picked, err = uiPick(db, dir)
+			} else {
+				target, err = strconv.ParseInt(fields[1], 10, 64)
+			}
+			if picked && err == nil {
+				err = uiGoto(db, conn, dir, target)
+			}
+		case "up", "u":
+			err = uiGoto(db, conn, dir, maxVersion)
+		case "down", "d":
+			err = uiDownOne(db, conn, dir)
+		case "help", "h", "?":
+			fmt.Println(uiHelp)
+		case "quit", "q", "exit":
+			return nil
+		default:
+			err = fmt.Errorf("unknown command %q, type help for the list of commands", fields[0])
+		}
+
+		if err != nil {
+			printError(os.Stderr, err, isTerminal(os.Stderr))
+		}
+	}
+}
+
+// uiList prints every migration with its state, applied time and
+// whether its script changed since it was applied.
+func uiList(db *sql.DB, dir string) error {
+	status, err := mig.StatusDB(db, dir)
+	if err != nil {
+		return err
+	}
+	current, err := mig.VersionDB(db)
+	if err != nil {
+		return err
+	}
+
+	if len(status) == 0 {
+		fmt.Println("No migrations found")
+		return nil
+	}
+
+	fmt.Printf("  %-16s %-8s %-24s %-9s %s\n", "Version", "State", "Applied At", "Checksum", "Migration")
+	for _, s := range status {
+		marker := " "
+		if s.Version == current {
+			marker = ">"
+		}
+
+		state, appliedAt, sum := "applied", s.Applied, "ok"
+		switch {
+		case s.Applied == "Pending":
+			state, appliedAt, sum = "pending", "", ""
+		case s.Orphaned:
+			state, sum = "orphaned", ""
+			s.Name = "unknown to the migration source"
+		case s.Modified:
+			sum = "modified"
+		case len(s.Checksum) == 0:
+			sum = "unknown"
+		}
+
+		fmt.Printf("%s %-16d %-8s %-24s %-9s %s\n", marker, s.Version, state, appliedAt, sum, s.Name)
+	}
+
+	fmt.Printf("\nCurrent version: %d\n", current)
+	return nil
+}
+
+// uiShow prints the Up, or Down, statements of a migration.
+func uiShow(db *sql.DB, dir string, args []string) error {
+	if len(args) < 1 {
+		return errors.New("no version provided")
+	}
+	version, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	up := len(args) < 2 || args[1] != "down"
+
+	status, err := mig.StatusDB(db, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range status {
+		if s.Version != version || s.Orphaned {
+			continue
+		}
+
+		stmts, err := mig.Statements(filepath.Join(dir, s.Name), up)
+		if err != nil {
+			return err
+		}
+
+		section := "Up"
+		if !up {
+			section = "Down"
+		}
+		fmt.Printf("%s statements of %s:\n", section, s.Name)
+		for i, stmt := range stmts {
+			fmt.Printf("\n-- statement %d\n%s\n", i+1, strings.TrimSpace(stmt))
+		}
+		if len(stmts) == 0 {
+			fmt.Println("  none")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("no migration with version %d", version)
+}
+
+// uiConfirm prints the migrations about to be applied or rolled back,
+// and asks for confirmation.
+func uiConfirm(conn, action string, plan []string) (bool, error) {
+	if len(plan) == 0 {
+		fmt.Println("No migrations to run")
+		return false, nil
+	}
+
+	if viper.GetBool("protected") {
+		err := confirmProtected(conn, action, func() ([]string, error) { return plan, nil })
+		return err == nil, err
+	}
+
+	fmt.Printf("About to %s:\n", action)
+	for _, name := range plan {
+		fmt.Printf("  %s\n", name)
+	}
+	fmt.Print("Proceed? [y/N] ")
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	if answer := strings.ToLower(strings.TrimSpace(line)); answer != "y" && answer != "yes" {
+		fmt.Println("Cancelled")
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// uiPick lists the versions the database can be migrated to, and reads
+// the number of the one picked. It reports false when none is picked.
+func uiPick(db *sql.DB, dir string) (int64, bool, error) {
+	status, err := mig.StatusDB(db, dir)
+	if err != nil {
+		return 0, false, err
+	}
+
+	targets := []int64{0}
+	fmt.Printf("  %3d  %-16d %s\n", 0, 0, "roll back every migration")
+	for _, s := range status {
+		if s.Orphaned {
+			continue
+		}
+		targets = append(targets, s.Version)
+
+		state := "applied"
+		if s.Applied == "Pending" {
+			state = "pending"
+		}
+		fmt.Printf("  %3d  %-16d %-8s %s\n", len(targets)-1, s.Version, state, s.Name)
+	}
+
+	fmt.Print("Migrate to number, or nothing to cancel: ")
+	line, err := stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, false, err
+	}
+	line = strings.TrimSpace(line)
+	if len(line) == 0 {
+		fmt.Println("Cancelled")
+		return 0, false, nil
+	}
+
+	i, err := strconv.Atoi(line)
+	if err != nil || i < 0 || i >= len(targets) {
+		return 0, false, fmt.Errorf("no migration numbered %q", line)
+	}
+
+	return targets[i], true, nil
+}
+
+// uiGoto migrates up or down to target after confirmation. Like the up and
+// down commands, it refuses to migrate a database with versions unknown to
+// dir unless --ignore-unknown is set.
+func uiGoto(db *sql.DB, conn, dir string, target int64) error {
+	status, err := mig.StatusDB(db, dir)
+	if err != nil {
+		return err
+	}
+	current, err := mig.VersionDB(db)
+	if err != nil {
+		return err
+	}
+
+	// fail before asking for a confirmation rather than after
+	var unknown []int64
+	for _, s := range status {
+		if s.Orphaned {
+			unknown = append(unknown, s.Version)
+		}
+	}
+	if len(unknown) > 0 && !mig.IgnoreUnknown {
+		return &mig.UnknownVersionError{Versions: unknown}
+	}
+
+	var plan []string
+	if target >= current {
+		for _, s := range status {
+			if s.Applied == "Pending" && s.Version > current && s.Version <= target {
+				plan = append(plan, s.Name)
+			}
+		}
+
+		ok, err := uiConfirm(conn, "apply", plan)
+		if !ok || err != nil {
+			return err
+		}
+
+		count, err := mig.UpToDB(db, dir, target)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Success   %d migrations\n", count)
+		return nil
+	}
+
+	for i := len(status) - 1; i >= 0; i-- {
+		if s := status[i]; s.Applied != "Pending" && !s.Orphaned && s.Version > target && s.Version <= current {
+			plan = append(plan, s.Name)
+		}
+	}
+
+	ok, err := uiConfirm(conn, "roll back", plan)
+	if !ok || err != nil {
+		return err
+	}
+
+	count, err := mig.DownToDB(db, dir, target)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Success   %d migrations\n", count)
+	return nil
+}
+
+// uiDownOne rolls back the latest migration after confirmation.
+func uiDownOne(db *sql.DB, conn, dir string) error {
+	current, err := mig.VersionDB(db)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		fmt.Println("No migrations to run")
+		return nil
+	}
+
+	// the version preceding the current one among the applied migrations
+	status, err := mig.StatusDB(db, dir)
+	if err != nil {
+		return err
+	}
+	previous := int64(0)
+	for _, s := range status {
+		if s.Applied != "Pending" && !s.Orphaned && s.Version < current {
+			previous = s.Version
+		}
+	}
+
+	return uiGoto(db, conn, dir, previous)
+}
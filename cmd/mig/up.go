@@ -26,7 +26,9 @@ var upOneCmd = &cobra.Command{
 
 func init() {
 	upCmd.Flags().StringP("dir", "d", ".", "directory with migration files")
+	upCmd.Flags().String("dump-schema", "", "file to dump the resulting schema to")
 	upOneCmd.Flags().StringP("dir", "d", ".", "directory with migration files")
+	upOneCmd.Flags().String("dump-schema", "", "file to dump the resulting schema to")
 
 	rootCmd.AddCommand(upCmd)
 	rootCmd.AddCommand(upOneCmd)
@@ -56,7 +58,7 @@ func upRunE(cmd *cobra.Command, args []string) error {
 		fmt.Printf("Success   %d migrations\n", count)
 	}
 
-	return nil
+	return dumpSchemaIfRequested(conn)
 }
 
 func upOneRunE(cmd *cobra.Command, args []string) error {
@@ -68,11 +70,11 @@ func upOneRunE(cmd *cobra.Command, args []string) error {
 	name, err := mig.UpOne(conn, viper.GetString("dir"))
 	if mig.IsNoMigrationError(err) {
 		fmt.Println("No migrations to run")
-		return nil
+		return dumpSchemaIfRequested(conn)
 	} else if err != nil {
 		return err
 	}
 
 	fmt.Printf("Success   %v\n", name)
-	return nil
+	return dumpSchemaIfRequested(conn)
 }
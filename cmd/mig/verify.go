@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/satriahrh/mig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that every pending migration can be rolled back",
+	Long: `Check that every pending migration can be rolled back, by running it up,
+down and up again and comparing the schema before and after its Down section.
+Meant for CI against a disposable database, exits non-zero with the schema
+differences of the first migration whose Down section is not its inverse.`,
+	Example: `$ mig verify "user:password@tcp(localhost:5555)/dbname" -d migrations`,
+	RunE:    verifyRunE,
+}
+
+func init() {
+	verifyCmd.Flags().StringP("dir", "d", ".", "directory with migration files")
+	addConfirmFlag(verifyCmd)
+
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.PreRun = func(*cobra.Command, []string) {
+		viper.BindPFlags(verifyCmd.Flags())
+	}
+}
+
+func verifyRunE(cmd *cobra.Command, args []string) error {
+	conn, err := getConnArgs(args)
+	if err != nil {
+		return err
+	}
+
+	err = confirmProtected(conn, "apply, roll back and reapply", func() ([]string, error) {
+		return pendingPlan(conn, viper.GetString("dir"))
+	})
+	if err != nil {
+		return err
+	}
+
+	count, err := mig.Verify(conn, viper.GetString("dir"))
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		fmt.Println("No migrations to verify")
+	} else {
+		fmt.Printf("Verified  %d migrations\n", count)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/satriahrh/mig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var waitCmd = &cobra.Command{
+	Use:   "wait",
+	Short: "Wait for the database to accept connections or reach a version",
+	Long: `Wait for the database to accept connections, and optionally for its
+schema to reach a version or the most recent version available, as migrated
+by another job. Meant for init containers, exits non-zero on timeout.`,
+	Example: `$ mig wait "user:password@tcp(localhost:5555)/dbname" --timeout 5m
+$ mig wait "user:password@tcp(localhost:5555)/dbname" --for-version 20170314221501
+$ mig wait "user:password@tcp(localhost:5555)/dbname" --for-head -d migrations`,
+	RunE:         waitRunE,
+	SilenceUsage: true,
+}
+
+func init() {
+	waitCmd.Flags().StringP("dir", "d", ".", "directory with migration files")
+	waitCmd.Flags().Int64("for-version", 0, "wait for the database to reach this version")
+	waitCmd.Flags().Bool("for-head", false, "wait for the database to be up to date with the migration files")
+	waitCmd.Flags().Duration("timeout", 5*time.Minute, "longest wait before giving up")
+
+	rootCmd.AddCommand(waitCmd)
+	waitCmd.PreRun = func(*cobra.Command, []string) {
+		viper.BindPFlags(waitCmd.Flags())
+	}
+}
+
+// Backoff between attempts, doubling from the first up to the last
+const (
+	waitMinBackoff = 500 * time.Millisecond
+	waitMaxBackoff = 10 * time.Second
+)
+
+// waitFor calls check with an exponential backoff until it succeeds, logging
+// each failed attempt, and returns the last error once ctx is done.
+func waitFor(ctx context.Context, what string, check func(context.Context) error) error {
+	backoff := waitMinBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := check(ctx)
+		if err == nil {
+			return nil
+		}
+
+		fmt.Printf("Waiting   for %s, attempt %d: %v\n", what, attempt, redact(err.Error()))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s: %v", what, err)
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > waitMaxBackoff {
+			backoff = waitMaxBackoff
+		}
+	}
+}
+
+func waitRunE(cmd *cobra.Command, args []string) error {
+	conn, err := getConnArgs(args)
+	if err != nil {
+		return err
+	}
+
+	version, head := viper.GetInt64("for-version"), viper.GetBool("for-head")
+	if version > 0 && head {
+		return errors.New("only one of --for-version and --for-head can be provided")
+	}
+
+	db, err := mig.Open(conn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := mig.SetDialect(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("timeout"))
+	defer cancel()
+
+	if err := waitFor(ctx, "the database", db.PingContext); err != nil {
+		return err
+	}
+
+	switch {
+	case version > 0:
+		err = waitFor(ctx, fmt.Sprintf("version %d", version), func(ctx context.Context) error {
+			return checkVersion(ctx, db, version)
+		})
+	case head:
+		var latest int64
+		if latest, err = mig.LatestVersion(viper.GetString("dir")); err != nil {
+			return err
+		}
+		err = waitFor(ctx, fmt.Sprintf("the most recent version %d", latest), func(ctx context.Context) error {
+			return checkVersion(ctx, db, latest)
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	current, err := mig.ReadVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Ready     at version %d\n", current)
+	return nil
+}
+
+// checkVersion fails while the database is below version. It only reads
+// the database, leaving the version table to the migrating job.
+func checkVersion(ctx context.Context, db *sql.DB, version int64) error {
+	current, err := mig.ReadVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if current < version {
+		return fmt.Errorf("database at version %d", current)
+	}
+
+	return nil
+}
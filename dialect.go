@@ -2,14 +2,30 @@ package mig
 
 import (
 	"database/sql"
+	"fmt"
 )
 
 // sqlDialect abstracts the details of specific SQL dialects
 // for mig's few SQL specific statements
 type sqlDialect interface {
-	createVersionTableSQL() string // sql string to create the mig_migrations table
-	insertVersionSQL() string      // sql string to insert the initial version table row
+	createVersionTableSQL() string    // sql string to create the VersionTable table
+	insertVersionSQL() string         // sql string to insert the initial version table row
+	insertChecksumVersionSQL() string // sql string to insert a version table row along with the checksum of its script
 	versionQuery(db *sql.DB) (*sql.Rows, error)
+	historyQuery() string        // sql string to select every version table row, most recent first
+	versionRecordsQuery() string // sql string to select the version and state of every version table row, most recent first
+
+	createMetaTableSQL() string   // sql string to create the VersionTable_meta table
+	metaVersionQuery() string     // sql string to select the metadata schema version
+	upsertMetaVersionSQL() string // sql string to record the metadata schema version
+	versionTableUpgrades() []string
+	lockSQL() string   // sql string to acquire the migration lock
+	unlockSQL() string // sql string to release the migration lock
+
+	batchProgressQuery() string      // sql string to select the next key of a batched statement
+	upsertBatchProgressSQL() string  // sql string to record the next key of a batched statement
+	deleteBatchProgressSQL() string  // sql string to delete the progress of a migration's batches
+	interruptedBatchesQuery() string // sql string to select the versions with batches in progress
 }
 
 var dialect sqlDialect = &mySQLDialect{}
@@ -35,24 +51,96 @@ func setDialect() error {
 type mySQLDialect struct{}
 
 func (mySQLDialect) createVersionTableSQL() string {
-	return `CREATE TABLE mig_migrations (
+	return fmt.Sprintf(`CREATE TABLE %s (
                 id serial NOT NULL,
                 version_id bigint NOT NULL,
                 is_applied boolean NOT NULL,
                 tstamp timestamp NULL default now(),
                 PRIMARY KEY(id)
-            );`
+            );`, VersionTable)
 }
 
 func (mySQLDialect) insertVersionSQL() string {
-	return "INSERT INTO mig_migrations (version_id, is_applied) VALUES (?, ?);"
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES (?, ?);", VersionTable)
+}
+
+func (mySQLDialect) insertChecksumVersionSQL() string {
+	return fmt.Sprintf("INSERT INTO %s (version_id, is_applied, checksum) VALUES (?, ?, ?);", VersionTable)
 }
 
 func (mySQLDialect) versionQuery(db *sql.DB) (*sql.Rows, error) {
-	rows, err := db.Query("SELECT version_id, is_applied from mig_migrations ORDER BY id DESC")
+	rows, err := db.Query(fmt.Sprintf("SELECT version_id, is_applied from %s ORDER BY id DESC", VersionTable))
 	if err != nil {
 		return nil, err
 	}
 
 	return rows, err
 }
+
+func (mySQLDialect) historyQuery() string {
+	return fmt.Sprintf("SELECT version_id, is_applied, tstamp FROM %s WHERE version_id > 0 ORDER BY id DESC", VersionTable)
+}
+
+func (mySQLDialect) versionRecordsQuery() string {
+	return fmt.Sprintf("SELECT version_id, is_applied FROM %s ORDER BY id DESC", VersionTable)
+}
+
+func (mySQLDialect) createMetaTableSQL() string {
+	return fmt.Sprintf(`CREATE TABLE %s_meta (
+                id int NOT NULL,
+                schema_version int NOT NULL,
+                PRIMARY KEY(id)
+            );`, VersionTable)
+}
+
+func (mySQLDialect) metaVersionQuery() string {
+	return fmt.Sprintf("SELECT schema_version FROM %s_meta WHERE id = 1", VersionTable)
+}
+
+func (mySQLDialect) upsertMetaVersionSQL() string {
+	return fmt.Sprintf(`INSERT INTO %s_meta (id, schema_version) VALUES (1, ?)
+            ON DUPLICATE KEY UPDATE schema_version = VALUES(schema_version);`, VersionTable)
+}
+
+// versionTableUpgrades returns the statements bringing a VersionTable table
+// created by createVersionTableSQL up to date. Steps are only ever appended;
+// the metadata schema version is the number of steps applied.
+func (mySQLDialect) versionTableUpgrades() []string {
+	return []string{
+		fmt.Sprintf("CREATE INDEX %[1]s_version_id_idx ON %[1]s (version_id);", VersionTable),
+		fmt.Sprintf(`CREATE TABLE %s_batches (
+                version_id bigint NOT NULL,
+                is_applied boolean NOT NULL,
+                statement int NOT NULL,
+                next_key bigint NOT NULL,
+                tstamp timestamp NULL default now(),
+                PRIMARY KEY(version_id, is_applied, statement)
+            );`, VersionTable),
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN checksum char(64) NULL;", VersionTable),
+	}
+}
+
+func (mySQLDialect) lockSQL() string {
+	return fmt.Sprintf("SELECT GET_LOCK('%s', ?)", VersionTable)
+}
+
+func (mySQLDialect) unlockSQL() string {
+	return fmt.Sprintf("SELECT RELEASE_LOCK('%s')", VersionTable)
+}
+
+func (mySQLDialect) batchProgressQuery() string {
+	return fmt.Sprintf("SELECT next_key FROM %s_batches WHERE version_id = ? AND is_applied = ? AND statement = ?", VersionTable)
+}
+
+func (mySQLDialect) upsertBatchProgressSQL() string {
+	return fmt.Sprintf(`INSERT INTO %s_batches (version_id, is_applied, statement, next_key) VALUES (?, ?, ?, ?)
+            ON DUPLICATE KEY UPDATE next_key = VALUES(next_key), tstamp = now();`, VersionTable)
+}
+
+func (mySQLDialect) deleteBatchProgressSQL() string {
+	return fmt.Sprintf("DELETE FROM %s_batches WHERE version_id = ? AND is_applied = ?;", VersionTable)
+}
+
+func (mySQLDialect) interruptedBatchesQuery() string {
+	return fmt.Sprintf("SELECT DISTINCT version_id FROM %s_batches ORDER BY version_id", VersionTable)
+}
@@ -0,0 +1,225 @@
+package mig
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SchemaDifference is a single difference between the expected
+// schema and the schema of a database.
+type SchemaDifference struct {
+	Kind     string // table, column, index, foreign key, view, function, procedure, trigger or version
+	Object   string // name of the differing object, tables qualify their columns and indexes
+	Expected string // expected definition, empty if the object is unexpected
+	Actual   string // actual definition, empty if the object is missing
+}
+
+func (d SchemaDifference) String() string {
+	switch {
+	case len(d.Actual) == 0:
+		return fmt.Sprintf("missing %s %s:\n  - %s", d.Kind, d.Object, d.Expected)
+	case len(d.Expected) == 0:
+		return fmt.Sprintf("unexpected %s %s:\n  + %s", d.Kind, d.Object, d.Actual)
+	default:
+		return fmt.Sprintf("changed %s %s:\n  - %s\n  + %s", d.Kind, d.Object, d.Expected, d.Actual)
+	}
+}
+
+var (
+	dumpVersionRegexp   = regexp.MustCompile(`(?m)^-- version: (\d+)$`)
+	createObjectRegexp  = regexp.MustCompile("(?s)^CREATE\\b.*?\\b(TABLE|VIEW|FUNCTION|PROCEDURE|TRIGGER)\\s+`((?:[^`]|``)*)`")
+	tableElementRegexp  = regexp.MustCompile("^(?:(PRIMARY) KEY|(?:UNIQUE |FULLTEXT |SPATIAL )?KEY `((?:[^`]|``)*)`|CONSTRAINT `((?:[^`]|``)*)` (FOREIGN KEY|CHECK)|`((?:[^`]|``)*)`)")
+	tableElementKindMap = map[string]string{"FOREIGN KEY": "foreign key", "CHECK": "check"}
+)
+
+// readSchemaDump reads back a schema dump written by schemaDump.write.
+func readSchemaDump(r io.Reader) (*schemaDump, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dump := &schemaDump{}
+	if m := dumpVersionRegexp.FindSubmatch(b); m != nil {
+		if dump.version, err = strconv.ParseInt(string(m[1]), 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid schema dump version: %v", err)
+		}
+	}
+
+	stmts, err := splitSQLStatements(bytes.NewReader(b), true)
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema dump: %v", err)
+	}
+
+	for _, stmt := range stmts {
+		var lines []string
+		for _, line := range strings.Split(stmt.query, "\n") {
+			if !strings.HasPrefix(line, sqlCmdPrefix) {
+				lines = append(lines, line)
+			}
+		}
+
+		definition := normalizeDefinition(strings.Join(lines, "\n"))
+		m := createObjectRegexp.FindStringSubmatch(definition)
+		if m == nil {
+			continue
+		}
+
+		dump.objects = append(dump.objects, schemaObject{
+			kind:       m[1],
+			name:       strings.Replace(m[2], "``", "`", -1),
+			definition: definition,
+		})
+	}
+
+	return dump, nil
+}
+
+// tableElement is a column, index or constraint of a table definition
+type tableElement struct {
+	kind       string
+	name       string
+	definition string
+}
+
+// tableElements breaks a normalized CREATE TABLE statement down into its
+// columns, indexes and constraints, keyed by kind and name. The table
+// options following the closing parenthesis are keyed as "table options".
+func tableElements(definition string) map[string]tableElement {
+	elements := map[string]tableElement{}
+
+	lines := strings.Split(definition, "\n")
+	for i, line := range lines {
+		if i == 0 {
+			continue
+		}
+
+		line = strings.TrimSuffix(strings.TrimSpace(line), ",")
+		if i == len(lines)-1 && strings.HasPrefix(line, ")") {
+			elements["table options"] = tableElement{kind: "table options", definition: line}
+			continue
+		}
+
+		m := tableElementRegexp.FindStringSubmatch(line)
+		var e tableElement
+		switch {
+		case m == nil:
+			e = tableElement{kind: "table", name: line}
+		case len(m[1]) > 0:
+			e = tableElement{kind: "index", name: "PRIMARY"}
+		case len(m[2]) > 0:
+			e = tableElement{kind: "index", name: m[2]}
+		case len(m[3]) > 0:
+			e = tableElement{kind: tableElementKindMap[m[4]], name: m[3]}
+		default:
+			e = tableElement{kind: "column", name: m[5]}
+		}
+		e.definition = line
+		elements[e.kind+" "+e.name] = e
+	}
+
+	return elements
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// diffTables compares two definitions of the same table element by element.
+func diffTables(name, expected, actual string) []SchemaDifference {
+	var diffs []SchemaDifference
+
+	expectedElements := tableElements(expected)
+	actualElements := tableElements(actual)
+
+	keys := map[string]bool{}
+	for k := range expectedElements {
+		keys[k] = true
+	}
+	for k := range actualElements {
+		keys[k] = true
+	}
+
+	for _, k := range sortedKeys(keys) {
+		e, a := expectedElements[k], actualElements[k]
+		if e.definition == a.definition {
+			continue
+		}
+
+		element := e
+		if len(element.kind) == 0 {
+			element = a
+		}
+
+		object := name
+		if len(element.name) > 0 && element.kind != "table" {
+			object = name + "." + element.name
+		}
+
+		diffs = append(diffs, SchemaDifference{
+			Kind:     element.kind,
+			Object:   object,
+			Expected: e.definition,
+			Actual:   a.definition,
+		})
+	}
+
+	return diffs
+}
+
+// diffSchemas lists the differences between an expected and an actual schema.
+func diffSchemas(expected, actual *schemaDump) []SchemaDifference {
+	var diffs []SchemaDifference
+
+	if expected.version != actual.version {
+		diffs = append(diffs, SchemaDifference{
+			Kind:     "version",
+			Object:   VersionTable,
+			Expected: strconv.FormatInt(expected.version, 10),
+			Actual:   strconv.FormatInt(actual.version, 10),
+		})
+	}
+
+	expectedObjects := map[string]schemaObject{}
+	actualObjects := map[string]schemaObject{}
+	keys := map[string]bool{}
+	for _, o := range expected.objects {
+		expectedObjects[o.kind+" "+o.name] = o
+		keys[o.kind+" "+o.name] = true
+	}
+	for _, o := range actual.objects {
+		actualObjects[o.kind+" "+o.name] = o
+		keys[o.kind+" "+o.name] = true
+	}
+
+	for _, k := range sortedKeys(keys) {
+		e, eok := expectedObjects[k]
+		a, aok := actualObjects[k]
+
+		switch {
+		case eok && aok && e.definition == a.definition:
+			continue
+		case eok && aok && e.kind == "TABLE":
+			diffs = append(diffs, diffTables(e.name, e.definition, a.definition)...)
+		case eok:
+			diffs = append(diffs, SchemaDifference{Kind: strings.ToLower(e.kind), Object: e.name, Expected: e.definition, Actual: a.definition})
+		default:
+			diffs = append(diffs, SchemaDifference{Kind: strings.ToLower(a.kind), Object: a.name, Actual: a.definition})
+		}
+	}
+
+	return diffs
+}
@@ -0,0 +1,106 @@
+package mig
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestReadSchemaDump(t *testing.T) {
+
+	dump := &schemaDump{
+		objects: []schemaObject{
+			{kind: "TABLE", name: "users", definition: "CREATE TABLE `users` (\n  `id` int NOT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB"},
+			{kind: "VIEW", name: "v", definition: "CREATE ALGORITHM=UNDEFINED SQL SECURITY DEFINER VIEW `v` AS select 1 AS `1`"},
+			{kind: "PROCEDURE", name: "p", definition: "CREATE PROCEDURE `p`()\nBEGIN\n  SELECT 1;\nEND"},
+		},
+		version:  20190102000000,
+		versions: []int64{0, 20190102000000},
+	}
+
+	var buf bytes.Buffer
+	if err := dump.write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := readSchemaDump(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diffs := diffSchemas(dump, read); len(diffs) != 0 {
+		t.Errorf("expected no differences, got %v", diffs)
+	}
+}
+
+func TestDiffSchemas(t *testing.T) {
+
+	expected := &schemaDump{
+		version: 2,
+		objects: []schemaObject{
+			{kind: "TABLE", name: "users", definition: "CREATE TABLE `users` (\n  `id` int NOT NULL,\n  `name` text,\n  PRIMARY KEY (`id`),\n  KEY `name_idx` (`name`(10))\n) ENGINE=InnoDB"},
+			{kind: "VIEW", name: "v", definition: "CREATE VIEW `v` AS select 1 AS `1`"},
+		},
+	}
+	actual := &schemaDump{
+		version: 1,
+		objects: []schemaObject{
+			{kind: "TABLE", name: "users", definition: "CREATE TABLE `users` (\n  `id` bigint NOT NULL,\n  `name` text,\n  PRIMARY KEY (`id`),\n  CONSTRAINT `fk` FOREIGN KEY (`id`) REFERENCES `x` (`id`)\n) ENGINE=InnoDB"},
+			{kind: "TRIGGER", name: "t", definition: "CREATE TRIGGER `t` BEFORE INSERT ON `users` FOR EACH ROW SET @x = 1"},
+		},
+	}
+
+	want := []SchemaDifference{
+		{Kind: "version", Object: "mig_migrations", Expected: "2", Actual: "1"},
+		{Kind: "column", Object: "users.id", Expected: "`id` int NOT NULL", Actual: "`id` bigint NOT NULL"},
+		{Kind: "foreign key", Object: "users.fk", Actual: "CONSTRAINT `fk` FOREIGN KEY (`id`) REFERENCES `x` (`id`)"},
+		{Kind: "index", Object: "users.name_idx", Expected: "KEY `name_idx` (`name`(10))"},
+		{Kind: "trigger", Object: "t", Actual: "CREATE TRIGGER `t` BEFORE INSERT ON `users` FOR EACH ROW SET @x = 1"},
+		{Kind: "view", Object: "v", Expected: "CREATE VIEW `v` AS select 1 AS `1`"},
+	}
+
+	diffs := diffSchemas(expected, actual)
+	if len(diffs) != len(want) {
+		t.Fatalf("incorrect number of differences. got %v, want %v", diffs, want)
+	}
+	for i := range want {
+		if diffs[i] != want[i] {
+			t.Errorf("incorrect difference. got %+v, want %+v", diffs[i], want[i])
+		}
+	}
+}
+
+func TestDriftReadOnly(t *testing.T) {
+
+	var expected bytes.Buffer
+	if err := (&schemaDump{version: 2, versions: []int64{1, 2}}).write(&expected); err != nil {
+		t.Fatal(err)
+	}
+
+	// a database without a version table, which drift must not create
+	db := sql.OpenDB(&fakeDB{handle: func(query string, args []driver.NamedValue) ([][]driver.Value, error) {
+		switch {
+		case strings.HasPrefix(query, "SELECT version_id, is_applied FROM mig_migrations "),
+			strings.HasPrefix(query, "SELECT schema_version FROM mig_migrations_meta"):
+			return nil, &mysql.MySQLError{Number: 1146}
+		case strings.Contains(query, "information_schema"):
+			return nil, nil
+		}
+		t.Errorf("unexpected query %s", query)
+		return nil, errors.New("unexpected query")
+	}})
+	defer db.Close()
+
+	diffs, err := DriftDB(db, &expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].Kind != "version" || diffs[0].Actual != "0" {
+		t.Errorf("incorrect differences. got %v, want the version only", diffs)
+	}
+}
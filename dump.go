@@ -0,0 +1,367 @@
+package mig
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// schemaObject is a single object of a database schema
+// along with the statement creating it.
+type schemaObject struct {
+	kind       string // TABLE, VIEW, PROCEDURE, FUNCTION or TRIGGER
+	name       string
+	definition string // normalized CREATE statement without the trailing semicolon
+}
+
+// schemaDump is a deterministic snapshot of a database schema.
+type schemaDump struct {
+	objects     []schemaObject
+	version     int64   // current version of the database
+	versions    []int64 // versions applied to the database, ascending
+	metaVersion int     // schema version of the VersionTable table
+}
+
+// order in which each kind of object is dumped, so that objects are
+// created after the objects they are likely to depend on.
+var schemaKindOrder = map[string]int{
+	"TABLE":     0,
+	"VIEW":      1,
+	"FUNCTION":  2,
+	"PROCEDURE": 3,
+	"TRIGGER":   4,
+}
+
+// column of the SHOW CREATE result holding the definition of each kind of object
+var showCreateColumn = map[string]string{
+	"TABLE":     "Create Table",
+	"VIEW":      "Create View",
+	"FUNCTION":  "Create Function",
+	"PROCEDURE": "Create Procedure",
+	"TRIGGER":   "SQL Original Statement",
+}
+
+var (
+	autoIncrementRegexp = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+	definerRegexp       = regexp.MustCompile("DEFINER=`[^`]*`@`[^`]*` ")
+)
+
+// normalizeDefinition strips the parts of a SHOW CREATE statement that
+// vary between otherwise identical databases, such as AUTO_INCREMENT
+// counters and definers.
+func normalizeDefinition(definition string) string {
+	definition = autoIncrementRegexp.ReplaceAllString(definition, "")
+	definition = definerRegexp.ReplaceAllString(definition, "")
+
+	lines := strings.Split(strings.TrimSpace(definition), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+
+	return strings.TrimSuffix(strings.Join(lines, "\n"), ";")
+}
+
+// showCreate runs a SHOW CREATE statement and returns the value of the
+// named column holding the definition.
+func showCreate(db *sql.DB, query, column string) (string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		return "", sql.ErrNoRows
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return "", err
+	}
+
+	for i, c := range columns {
+		if c != column {
+			continue
+		}
+		if !values[i].Valid {
+			return "", fmt.Errorf("no definition returned by %q, missing privileges?", query)
+		}
+		return values[i].String, nil
+	}
+
+	return "", fmt.Errorf("no %s column returned by %q", column, query)
+}
+
+// quoteIdentifier quotes a MySQL identifier with backticks.
+func quoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// listSchemaObjects lists the kind and name of every table, view,
+// routine and trigger in the current database.
+func listSchemaObjects(db *sql.DB) ([]schemaObject, error) {
+	queries := []string{
+		`SELECT IF(TABLE_TYPE = 'VIEW', 'VIEW', 'TABLE'), TABLE_NAME
+            FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE()`,
+		`SELECT ROUTINE_TYPE, ROUTINE_NAME
+            FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = DATABASE()`,
+		`SELECT 'TRIGGER', TRIGGER_NAME
+            FROM information_schema.TRIGGERS WHERE TRIGGER_SCHEMA = DATABASE()`,
+	}
+
+	var objects []schemaObject
+	for _, q := range queries {
+		rows, err := db.Query(q)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var o schemaObject
+			if err := rows.Scan(&o.kind, &o.name); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error scanning rows: %s", err)
+			}
+			objects = append(objects, o)
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].kind != objects[j].kind {
+			return schemaKindOrder[objects[i].kind] < schemaKindOrder[objects[j].kind]
+		}
+		return objects[i].name < objects[j].name
+	})
+
+	return objects, nil
+}
+
+// dumpSchemaObjects returns the normalized definition of every object
+// in the current database.
+func dumpSchemaObjects(db *sql.DB) ([]schemaObject, error) {
+	objects, err := listSchemaObjects(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, o := range objects {
+		q := fmt.Sprintf("SHOW CREATE %s %s", o.kind, quoteIdentifier(o.name))
+		definition, err := showCreate(db, q, showCreateColumn[o.kind])
+		if err != nil {
+			return nil, fmt.Errorf("error dumping %s %s: %v", strings.ToLower(o.kind), o.name, err)
+		}
+
+		objects[i].definition = normalizeDefinition(definition)
+	}
+
+	return orderViews(objects), nil
+}
+
+// orderViews orders the views among objects so that each view follows the
+// views its definition selects from, keeping them in name order otherwise.
+// Views are left in name order when their dependencies are circular.
+func orderViews(objects []schemaObject) []schemaObject {
+	var views []schemaObject
+	for _, o := range objects {
+		if o.kind == "VIEW" {
+			views = append(views, o)
+		}
+	}
+
+	// SHOW CREATE VIEW quotes every identifier it references
+	dependsOn := func(v, w schemaObject) bool {
+		return v.name != w.name && strings.Contains(v.definition, quoteIdentifier(w.name))
+	}
+
+	var ordered []schemaObject
+	done := map[string]bool{}
+	for len(ordered) < len(views) {
+		progress := false
+		for _, v := range views {
+			if done[v.name] {
+				continue
+			}
+
+			ready := true
+			for _, w := range views {
+				if !done[w.name] && dependsOn(v, w) {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, v)
+				done[v.name] = true
+				progress = true
+				break
+			}
+		}
+
+		if !progress {
+			for _, v := range views {
+				if !done[v.name] {
+					ordered = append(ordered, v)
+					done[v.name] = true
+				}
+			}
+		}
+	}
+
+	result := make([]schemaObject, 0, len(objects))
+	for _, o := range objects {
+		if o.kind != "VIEW" {
+			result = append(result, o)
+			continue
+		}
+		result = append(result, ordered[0])
+		ordered = ordered[1:]
+	}
+
+	return result
+}
+
+// getAppliedVersions returns every version currently applied
+// to the database in ascending order.
+func getAppliedVersions(db *sql.DB) ([]int64, error) {
+	rows, err := getDialect().versionQuery(db)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// the most recent record for each version tells whether it is applied
+	seen := map[int64]bool{}
+	var versions []int64
+	for rows.Next() {
+		var row migrationRecord
+		if err := rows.Scan(&row.versionID, &row.isApplied); err != nil {
+			return nil, fmt.Errorf("error scanning rows: %s", err)
+		}
+
+		if seen[row.versionID] {
+			continue
+		}
+		seen[row.versionID] = true
+
+		if row.isApplied {
+			versions = append(versions, row.versionID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	return versions, nil
+}
+
+// getSchemaDump takes a snapshot of the schema of the database
+// along with its migration versions.
+func getSchemaDump(db *sql.DB) (*schemaDump, error) {
+	// read the versions without creating or upgrading the version table,
+	// so that comparing schemas needs no more than read privileges
+	version, versions, err := readVersions(context.Background(), db)
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := dumpSchemaObjects(db)
+	if err != nil {
+		return nil, err
+	}
+
+	metaVersion, err := getMetaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schemaDump{
+		objects:     objects,
+		version:     version,
+		versions:    versions,
+		metaVersion: metaVersion,
+	}, nil
+}
+
+// needsStatementBlock reports whether a statement must be wrapped in
+// StatementBegin and StatementEnd annotations for splitSQLStatements
+// to read it back as a single statement.
+func needsStatementBlock(o schemaObject) bool {
+	switch o.kind {
+	case "PROCEDURE", "FUNCTION", "TRIGGER":
+		return true
+	}
+
+	lines := strings.Split(o.definition, "\n")
+	for _, line := range lines[:len(lines)-1] {
+		if endsWithSemicolon(line) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeStatement writes a single statement of a dump to w.
+func writeStatement(w *bufio.Writer, o schemaObject) {
+	if needsStatementBlock(o) {
+		fmt.Fprintf(w, "%sStatementBegin\n%s;\n%sStatementEnd\n\n", sqlCmdPrefix, o.definition, sqlCmdPrefix)
+		return
+	}
+
+	fmt.Fprintf(w, "%s;\n\n", o.definition)
+}
+
+// write writes the dump to w as an Up-only migration script,
+// so that it can be read back with splitSQLStatements.
+func (s *schemaDump) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "-- mig schema dump\n-- version: %d\n\n", s.version)
+	fmt.Fprintf(bw, "%sUp\n", sqlCmdPrefix)
+	fmt.Fprint(bw, "SET FOREIGN_KEY_CHECKS = 0;\n\n")
+
+	for _, o := range s.objects {
+		writeStatement(bw, o)
+	}
+
+	if len(s.versions) > 0 {
+		values := make([]string, len(s.versions))
+		for i, v := range s.versions {
+			values[i] = fmt.Sprintf("(%d, 1)", v)
+		}
+		fmt.Fprintf(bw, "INSERT INTO %s (version_id, is_applied) VALUES\n%s;\n\n", VersionTable, strings.Join(values, ",\n"))
+	}
+
+	if s.metaVersion > 0 {
+		fmt.Fprintf(bw, "INSERT INTO %s_meta (id, schema_version) VALUES (1, %d);\n\n", VersionTable, s.metaVersion)
+	}
+
+	fmt.Fprint(bw, "SET FOREIGN_KEY_CHECKS = 1;\n")
+
+	return bw.Flush()
+}
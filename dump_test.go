@@ -0,0 +1,185 @@
+package mig
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeDefinition(t *testing.T) {
+
+	type testData struct {
+		definition string
+		result     string
+	}
+
+	tests := []testData{
+		{
+			definition: "CREATE TABLE `users` (\n  `id` int NOT NULL AUTO_INCREMENT,  \n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB AUTO_INCREMENT=42 DEFAULT CHARSET=utf8mb4",
+			result:     "CREATE TABLE `users` (\n  `id` int NOT NULL AUTO_INCREMENT,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+		},
+		{
+			definition: "CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`%` SQL SECURITY DEFINER VIEW `v` AS select 1 AS `1`",
+			result:     "CREATE ALGORITHM=UNDEFINED SQL SECURITY DEFINER VIEW `v` AS select 1 AS `1`",
+		},
+	}
+
+	for _, test := range tests {
+		r := normalizeDefinition(test.definition)
+		if r != test.result {
+			t.Errorf("incorrect definition. got %q, want %q", r, test.result)
+		}
+	}
+}
+
+func TestSchemaDumpWrite(t *testing.T) {
+
+	dump := &schemaDump{
+		objects: []schemaObject{
+			{kind: "TABLE", name: "users", definition: "CREATE TABLE `users` (\n  `id` int NOT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB"},
+			{kind: "PROCEDURE", name: "p", definition: "CREATE PROCEDURE `p`()\nBEGIN\n  SELECT 1;\n  SELECT 2;\nEND"},
+		},
+		version:     20190102000000,
+		versions:    []int64{0, 20190101000000, 20190102000000},
+		metaVersion: 1,
+	}
+
+	var buf bytes.Buffer
+	if err := dump.write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "-- version: 20190102000000\n") {
+		t.Errorf("missing version header in dump:\n%s", buf.String())
+	}
+
+	stmts, err := splitSQLStatements(&buf, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SET, table, procedure, versions, meta version, SET
+	if len(stmts) != 6 {
+		t.Errorf("incorrect number of stmts. got %v, want %v", len(stmts), 6)
+	}
+}
+
+func TestWriteSquashedMigration(t *testing.T) {
+
+	objects := []schemaObject{
+		{kind: "TABLE", name: "users", definition: "CREATE TABLE `users` (\n  `id` int NOT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB"},
+		{kind: "TRIGGER", name: "t", definition: "CREATE TRIGGER `t` BEFORE INSERT ON `users` FOR EACH ROW BEGIN\n  SET @x = 1;\nEND"},
+	}
+	squashed := migrations{newMigration(1, "1_a.sql"), newMigration(2, "2_b.sql")}
+
+	var buf bytes.Buffer
+	if err := writeSquashedMigration(&buf, objects, squashed); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, direction := range []bool{true, false} {
+		stmts, err := splitSQLStatements(strings.NewReader(buf.String()), direction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// SET, one statement per object, SET
+		if len(stmts) != 4 {
+			t.Errorf("incorrect number of stmts. got %v, want %v", len(stmts), 4)
+		}
+	}
+
+	if !strings.Contains(buf.String(), "DROP TRIGGER `t`;\nDROP TABLE `users`;\n") {
+		t.Errorf("objects not dropped in reverse order:\n%s", buf.String())
+	}
+}
+
+func TestSquashedVersionsAreKnown(t *testing.T) {
+
+	dir := t.TempDir()
+
+	squashed := migrations{newMigration(1, "1_a.sql"), newMigration(2, "2_b.sql")}
+	var buf bytes.Buffer
+	if err := writeSquashedMigration(&buf, nil, squashed); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"2_squashed.sql": buf.String(),
+		"3_c.sql":        "-- +mig Up\nSELECT 1;\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := squashedVersions(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions, []int64{1, 2}) {
+		t.Errorf("incorrect squashed versions. got %v, want %v", versions, []int64{1, 2})
+	}
+
+	// a database migrated before the squash still records versions 1 and 2
+	unknown, err := unknownAmong(dir, []int64{1, 2, 3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(unknown, []int64{4}) {
+		t.Errorf("incorrect unknown versions. got %v, want %v", unknown, []int64{4})
+	}
+
+	err = &PartialSquashError{Migration: "2_squashed.sql", Versions: []int64{1}}
+	if !errors.Is(err, ErrPartialSquash) {
+		t.Errorf("%v does not match ErrPartialSquash", err)
+	}
+}
+
+func TestOrderViews(t *testing.T) {
+
+	objects := []schemaObject{
+		{kind: "TABLE", name: "users"},
+		{kind: "VIEW", name: "active_admins", definition: "CREATE VIEW `active_admins` AS select `a`.`id` AS `id` from `active_users` `a` join `admins`"},
+		{kind: "VIEW", name: "active_users", definition: "CREATE VIEW `active_users` AS select `users`.`id` AS `id` from `users`"},
+		{kind: "VIEW", name: "admins", definition: "CREATE VIEW `admins` AS select `users`.`id` AS `id` from `users`"},
+		{kind: "TRIGGER", name: "t"},
+	}
+
+	var names []string
+	for _, o := range orderViews(objects) {
+		names = append(names, o.name)
+	}
+	if want := []string{"users", "active_users", "admins", "active_admins", "t"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("incorrect order. got %v, want %v", names, want)
+	}
+}
+
+func TestCheckSquashedData(t *testing.T) {
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"1_create_post.sql": "-- +mig Up\nCREATE TABLE post (id int);\n\n-- +mig Down\nDELETE FROM post;\nDROP TABLE post;\n",
+		"2_seed_post.sql":   "-- +mig Up\nCREATE INDEX post_id ON post (id);\nINSERT INTO post VALUES (1);\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	schemaOnly := migrations{newMigration(1, filepath.Join(dir, "1_create_post.sql"))}
+	if err := checkSquashedData(schemaOnly); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	withData := append(schemaOnly, newMigration(2, filepath.Join(dir, "2_seed_post.sql")))
+	err := checkSquashedData(withData)
+	if err == nil || !strings.Contains(err.Error(), "2_seed_post.sql changes data at line 3") {
+		t.Errorf("incorrect error %v", err)
+	}
+}
@@ -1,5 +1,7 @@
 module github.com/satriahrh/mig
 
+go 1.15
+
 require (
 	github.com/go-sql-driver/mysql v1.4.1
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
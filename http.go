@@ -0,0 +1,200 @@
+package mig
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VersionChange is a version applied or rolled back, as recorded in the
+// version table
+type VersionChange struct {
+	Version int64     `json:"version"`
+	Applied bool      `json:"applied"`
+	Time    time.Time `json:"time"`
+}
+
+// getHistory returns every version applied or rolled back,
+// most recent first.
+func getHistory(db *sql.DB) ([]VersionChange, error) {
+	// must ensure that the version table exists if we're running on a pristine DB
+	if _, err := getVersion(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(getDialect().historyQuery())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []VersionChange{}
+	for rows.Next() {
+		var c VersionChange
+		var tstamp sql.NullTime
+		if err := rows.Scan(&c.Version, &c.Applied, &tstamp); err != nil {
+			return nil, fmt.Errorf("error scanning rows: %s", err)
+		}
+		c.Time = tstamp.Time
+		history = append(history, c)
+	}
+
+	return history, rows.Err()
+}
+
+// Handler serves the migration state of a database as JSON, to be
+// mounted by a service running its migrations on startup:
+//
+//	GET  /status   every migration with its status
+//	GET  /version  the current version
+//	GET  /pending  the migrations not applied yet
+//	GET  /history  every version applied or rolled back, most recent first
+//	GET  /ready    200 when the database is up to date, 503 otherwise, see ReadinessHandler
+//	POST /up       migrate up to the version parameter, or the most recent version
+//	POST /down     roll back to the version parameter, or by one version
+//
+// The POST endpoints are disabled unless Token is set, and require it as
+// a bearer token in the Authorization header. Paths are matched exactly, so
+// mount the handler under a prefix with http.StripPrefix.
+// Expects SetDialect to be called beforehand.
+type Handler struct {
+	DB    *sql.DB
+	Dir   string
+	Token string
+
+	mu sync.Mutex // serializes migrations triggered through the handler
+}
+
+// NewHandler returns a Handler serving the state of db migrated from dir.
+// token enables the POST endpoints when not empty.
+func NewHandler(db *sql.DB, dir, token string) *Handler {
+	return &Handler{DB: db, Dir: dir, Token: token}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/" + strings.TrimPrefix(r.URL.Path, "/")
+
+	switch endpoint {
+	case "/status", "/version", "/pending", "/history":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		h.serveState(w, endpoint)
+	case "/ready":
+		ReadinessHandler(h.DB, h.Dir).ServeHTTP(w, r)
+	case "/up", "/down":
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		h.serveMigrate(w, r, endpoint)
+	default:
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown endpoint %s", r.URL.Path))
+	}
+}
+
+// serveState serves the read only endpoints.
+func (h *Handler) serveState(w http.ResponseWriter, endpoint string) {
+	var v interface{}
+	var err error
+
+	switch endpoint {
+	case "/status":
+		v, err = StatusDB(h.DB, h.Dir)
+	case "/version":
+		var version int64
+		version, err = VersionDB(h.DB)
+		v = map[string]int64{"version": version}
+	case "/pending":
+		var status []MigrationStatus
+		status, err = StatusDB(h.DB, h.Dir)
+		pending := []MigrationStatus{}
+		for _, s := range status {
+			if s.Applied == "Pending" {
+				pending = append(pending, s)
+			}
+		}
+		v = pending
+	case "/history":
+		v, err = getHistory(h.DB)
+	}
+
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, v)
+}
+
+// serveMigrate serves the endpoints migrating the database.
+func (h *Handler) serveMigrate(w http.ResponseWriter, r *http.Request, endpoint string) {
+	if len(h.Token) == 0 {
+		writeJSONError(w, http.StatusForbidden, errors.New("migrating through the handler is disabled"))
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) != 1 {
+		writeJSONError(w, http.StatusUnauthorized, errors.New("invalid token"))
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	current, err := VersionDB(h.DB)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	target := int64(math.MaxInt64)
+	if endpoint == "/down" {
+		target = current - 1
+	}
+	if param := r.FormValue("version"); len(param) > 0 {
+		if target, err = strconv.ParseInt(param, 10, 64); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid version %q", param))
+			return
+		}
+	}
+
+	var count int
+	if endpoint == "/up" {
+		count, err = UpToDB(h.DB, h.Dir, target)
+	} else {
+		count, err = DownToDB(h.DB, h.Dir, target)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	version, err := VersionDB(h.DB)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int64{"count": int64(count), "version": version})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,47 @@
+package mig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerRejects(t *testing.T) {
+
+	tests := []struct {
+		handler *Handler
+		method  string
+		path    string
+		token   string
+		code    int
+	}{
+		{NewHandler(nil, ".", ""), http.MethodGet, "/unknown", "", http.StatusNotFound},
+		{NewHandler(nil, ".", ""), http.MethodPost, "/status", "", http.StatusMethodNotAllowed},
+		{NewHandler(nil, ".", ""), http.MethodGet, "/up", "", http.StatusMethodNotAllowed},
+		{NewHandler(nil, ".", ""), http.MethodPost, "/up", "secret", http.StatusForbidden},
+		{NewHandler(nil, ".", "secret"), http.MethodPost, "/down", "", http.StatusUnauthorized},
+		{NewHandler(nil, ".", "secret"), http.MethodPost, "/down", "wrong", http.StatusUnauthorized},
+		{NewHandler(nil, ".", "secret"), http.MethodPost, "/x/y/up", "secret", http.StatusNotFound},
+		{NewHandler(nil, ".", ""), http.MethodPost, "up", "secret", http.StatusForbidden},
+		{NewHandler(nil, ".", ""), http.MethodPost, "status", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		// as left by http.StripPrefix, which may leave no leading slash
+		r := httptest.NewRequest(test.method, "/", nil)
+		r.URL.Path = test.path
+		if len(test.token) > 0 {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+
+		test.handler.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%s %s: incorrect status. got %v, want %v", test.method, test.path, w.Code, test.code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: incorrect content type %q", test.method, test.path, ct)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package mig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Severity of a lint issue
+type Severity string
+
+// Severities of lint issues, rules set to SeverityOff are not run
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+	SeverityOff     Severity = "off"
+)
+
+// LintIssue is a problem found in a migration file by Lint
+type LintIssue struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s (%s)", i.File, i.Line, i.Severity, i.Message, i.Rule)
+}
+
+// LintRule is a check run by Lint against each migration file
+type LintRule struct {
+	Name        string
+	Description string
+	Severity    Severity // default severity of the issues found by the rule
+
+	check func(f *lintFile) []LintIssue
+}
+
+// lintFile is a migration file split into its up and down statements
+type lintFile struct {
+	path string
+	up   []sqlStatement
+	down []sqlStatement
+}
+
+var (
+	dropTableRegexp       = regexp.MustCompile(`(?is)^DROP\s+(?:TEMPORARY\s+)?TABLE\b`)
+	dropColumnRegexp      = regexp.MustCompile("(?is)^ALTER\\s+TABLE\\b.*\\bDROP\\s+(?:COLUMN\\s+)?(?:`[^`]+`|(?:\\w+))\\s*(?:,|$)")
+	dropIndexOrKeyRegexp  = regexp.MustCompile(`(?is)\bDROP\s+(?:INDEX|KEY|PRIMARY|FOREIGN|CONSTRAINT|CHECK|PARTITION)\b`)
+	truncateRegexp        = regexp.MustCompile(`(?is)^TRUNCATE\b`)
+	alterTableStartRegexp = regexp.MustCompile(`(?is)^ALTER\s+TABLE\b`)
+	algorithmRegexp       = regexp.MustCompile(`(?is)\b(?:ALGORITHM|LOCK)\s*=`)
+	createRegexp          = regexp.MustCompile(`(?is)^CREATE\s+(?:OR\s+REPLACE\s+)?(?:TEMPORARY\s+)?(TABLE|DATABASE|SCHEMA|VIEW|PROCEDURE|FUNCTION|TRIGGER)\b`)
+	ifNotExistsRegexp     = regexp.MustCompile(`(?is)^CREATE\s+(?:TEMPORARY\s+)?(?:TABLE|DATABASE|SCHEMA)\s+IF\s+NOT\s+EXISTS\b`)
+	orReplaceRegexp       = regexp.MustCompile(`(?is)^CREATE\s+OR\s+REPLACE\b`)
+	dropRegexp            = regexp.MustCompile(`(?is)^DROP\s+(?:TEMPORARY\s+)?(TABLE|DATABASE|SCHEMA|VIEW|PROCEDURE|FUNCTION|TRIGGER)\b`)
+	ifExistsRegexp        = regexp.MustCompile(`(?is)^DROP\s+(?:TEMPORARY\s+)?\w+\s+IF\s+EXISTS\b`)
+	createViewRegexp      = regexp.MustCompile(`(?is)^CREATE\b.*?\bVIEW\b`)
+	selectStarRegexp      = regexp.MustCompile(`(?is)\bSELECT\s+(?:DISTINCT\s+)?(?:\w+\.)?\*`)
+)
+
+// statementKeyword returns the first keyword of a statement in upper case.
+func statementKeyword(query string) string {
+	fields := strings.Fields(stripComments(query))
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return strings.ToUpper(strings.TrimRight(fields[0], "(;"))
+}
+
+// isDDL reports whether a statement changes the schema, which MySQL
+// commits implicitly.
+func isDDL(query string) bool {
+	switch statementKeyword(query) {
+	case "CREATE", "ALTER", "DROP", "RENAME", "TRUNCATE":
+		return true
+	}
+
+	return false
+}
+
+// isDML reports whether a statement changes the data of a table.
+func isDML(query string) bool {
+	switch statementKeyword(query) {
+	case "INSERT", "UPDATE", "DELETE", "REPLACE", "LOAD":
+		return true
+	}
+
+	return false
+}
+
+func (f *lintFile) issue(line int, format string, a ...interface{}) LintIssue {
+	return LintIssue{File: f.path, Line: line, Message: fmt.Sprintf(format, a...)}
+}
+
+func lintDestructive(f *lintFile) []LintIssue {
+	var issues []LintIssue
+	for _, stmt := range f.up {
+		if _, ok := stmt.directive("Destructive"); ok {
+			continue
+		}
+
+		q := stripComments(stmt.query)
+		switch {
+		case dropTableRegexp.MatchString(q):
+			issues = append(issues, f.issue(stmt.line, "DROP TABLE without a '-- +mig Destructive' annotation"))
+		case truncateRegexp.MatchString(q):
+			issues = append(issues, f.issue(stmt.line, "TRUNCATE without a '-- +mig Destructive' annotation"))
+		case dropColumnRegexp.MatchString(dropIndexOrKeyRegexp.ReplaceAllString(q, "")):
+			issues = append(issues, f.issue(stmt.line, "DROP COLUMN without a '-- +mig Destructive' annotation"))
+		}
+	}
+
+	return issues
+}
+
+func lintAlgorithm(f *lintFile) []LintIssue {
+	var issues []LintIssue
+	for _, stmts := range [][]sqlStatement{f.up, f.down} {
+		for _, stmt := range stmts {
+			if _, ok := stmt.directive("OnlineSchemaChange"); ok {
+				continue
+			}
+
+			q := stripComments(stmt.query)
+			if alterTableStartRegexp.MatchString(q) && !algorithmRegexp.MatchString(q) {
+				issues = append(issues, f.issue(stmt.line, "ALTER TABLE without an ALGORITHM or LOCK clause"))
+			}
+		}
+	}
+
+	return issues
+}
+
+func lintMissingDown(f *lintFile) []LintIssue {
+	if len(f.down) > 0 || len(f.up) == 0 {
+		return nil
+	}
+
+	return []LintIssue{f.issue(1, "no Down statements to roll the migration back")}
+}
+
+func lintIdempotent(f *lintFile) []LintIssue {
+	var issues []LintIssue
+	for _, stmts := range [][]sqlStatement{f.up, f.down} {
+		for _, stmt := range stmts {
+			q := stripComments(stmt.query)
+
+			if m := createRegexp.FindStringSubmatch(q); m != nil {
+				switch strings.ToUpper(m[1]) {
+				case "TABLE", "DATABASE", "SCHEMA":
+					if !ifNotExistsRegexp.MatchString(q) {
+						issues = append(issues, f.issue(stmt.line, "CREATE %s without IF NOT EXISTS", strings.ToUpper(m[1])))
+					}
+				case "VIEW":
+					if !orReplaceRegexp.MatchString(q) {
+						issues = append(issues, f.issue(stmt.line, "CREATE VIEW without OR REPLACE"))
+					}
+				}
+			}
+
+			if m := dropRegexp.FindStringSubmatch(q); m != nil && !ifExistsRegexp.MatchString(q) {
+				issues = append(issues, f.issue(stmt.line, "DROP %s without IF EXISTS", strings.ToUpper(m[1])))
+			}
+		}
+	}
+
+	return issues
+}
+
+func lintSelectStar(f *lintFile) []LintIssue {
+	var issues []LintIssue
+	for _, stmts := range [][]sqlStatement{f.up, f.down} {
+		for _, stmt := range stmts {
+			q := stripComments(stmt.query)
+			if createViewRegexp.MatchString(q) && selectStarRegexp.MatchString(q) {
+				issues = append(issues, f.issue(stmt.line, "SELECT * in a view freezes its columns at creation time"))
+			}
+		}
+	}
+
+	return issues
+}
+
+func lintMixedDDLDML(f *lintFile) []LintIssue {
+	var ddl, dml *sqlStatement
+	for i, stmt := range f.up {
+		if ddl == nil && isDDL(stmt.query) {
+			ddl = &f.up[i]
+		}
+		if dml == nil && isDML(stmt.query) {
+			dml = &f.up[i]
+		}
+	}
+
+	if ddl == nil || dml == nil {
+		return nil
+	}
+
+	line := ddl.line
+	if dml.line > line {
+		line = dml.line
+	}
+
+	return []LintIssue{f.issue(line, "schema changes (line %d) mixed with data changes (line %d) in one migration", ddl.line, dml.line)}
+}
+
+var lintRules = []LintRule{
+	{Name: "destructive", Description: "DROP TABLE, DROP COLUMN or TRUNCATE without a '-- +mig Destructive' annotation", Severity: SeverityError, check: lintDestructive},
+	{Name: "alter-algorithm", Description: "ALTER TABLE without an ALGORITHM or LOCK clause", Severity: SeverityWarning, check: lintAlgorithm},
+	{Name: "missing-down", Description: "migration without Down statements", Severity: SeverityWarning, check: lintMissingDown},
+	{Name: "idempotent", Description: "CREATE without IF NOT EXISTS or OR REPLACE, DROP without IF EXISTS", Severity: SeverityInfo, check: lintIdempotent},
+	{Name: "select-star-view", Description: "SELECT * in a view", Severity: SeverityWarning, check: lintSelectStar},
+	{Name: "mixed-ddl-dml", Description: "schema and data changes in the same migration", Severity: SeverityWarning, check: lintMixedDDLDML},
+}
+
+// LintRules returns the rules run by Lint along with their default severity.
+func LintRules() []LintRule {
+	rules := make([]LintRule, len(lintRules))
+	copy(rules, lintRules)
+	return rules
+}
+
+// readLintFile splits a migration file into its up and down statements.
+func readLintFile(path string) (*lintFile, error) {
+	f := &lintFile{path: path}
+
+	for _, direction := range []bool{true, false} {
+		r, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		stmts, err := splitSQLStatements(r, direction)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if direction {
+			f.up = stmts
+		} else {
+			f.down = stmts
+		}
+	}
+
+	return f, nil
+}
+
+// Lint checks every migration file in dir for destructive, locking or
+// otherwise risky statements. severities overrides the default severity
+// of rules by name, rules set to SeverityOff are not run.
+func Lint(dir string, severities map[string]Severity) ([]LintIssue, error) {
+	for name, severity := range severities {
+		switch severity {
+		case SeverityError, SeverityWarning, SeverityInfo, SeverityOff:
+		default:
+			return nil, fmt.Errorf("unknown severity %q for lint rule %q", severity, name)
+		}
+
+		found := false
+		for _, rule := range lintRules {
+			found = found || rule.Name == name
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown lint rule %q", name)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var issues []LintIssue
+	for _, path := range files {
+		f, err := readLintFile(path)
+		if err != nil {
+			line := 1
+			if e, ok := err.(*splitError); ok {
+				line, err = e.line, fmt.Errorf("%s", e.msg)
+			}
+			issues = append(issues, LintIssue{File: path, Line: line, Rule: "parse", Severity: SeverityError, Message: err.Error()})
+			continue
+		}
+
+		for _, rule := range lintRules {
+			severity := rule.Severity
+			if s, ok := severities[rule.Name]; ok {
+				severity = s
+			}
+			if severity == SeverityOff {
+				continue
+			}
+
+			for _, issue := range rule.check(f) {
+				issue.Rule = rule.Name
+				issue.Severity = severity
+				issues = append(issues, issue)
+			}
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].File != issues[j].File {
+			return issues[i].File < issues[j].File
+		}
+		return issues[i].Line < issues[j].Line
+	})
+
+	return issues, nil
+}
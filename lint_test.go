@@ -0,0 +1,78 @@
+package mig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "mig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"1_create.sql": `-- +mig Up
+CREATE TABLE IF NOT EXISTS post (id int NOT NULL);
+
+-- +mig Down
+DROP TABLE IF EXISTS post;
+`,
+		"2_risky.sql": `-- +mig Up
+ALTER TABLE post ADD COLUMN title text;
+ALTER TABLE post DROP COLUMN body, ALGORITHM=INPLACE;
+ALTER TABLE post DROP INDEX title_idx, ALGORITHM=INPLACE;
+DROP TABLE IF EXISTS comments;
+-- +mig Destructive
+DROP TABLE IF EXISTS likes;
+UPDATE post SET title = '';
+CREATE VIEW post_view AS SELECT * FROM post;
+`,
+		"3_broken.sql": `-- +mig Up
+CREATE TABLE IF NOT EXISTS broken (id int NOT NULL)
+`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	issues, err := Lint(dir, map[string]Severity{"idempotent": SeverityOff})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type want struct {
+		file string
+		line int
+		rule string
+	}
+	wants := []want{
+		{"2_risky.sql", 1, "missing-down"},
+		{"2_risky.sql", 2, "alter-algorithm"},
+		{"2_risky.sql", 3, "destructive"},
+		{"2_risky.sql", 5, "destructive"},
+		{"2_risky.sql", 8, "mixed-ddl-dml"},
+		{"2_risky.sql", 9, "select-star-view"},
+		{"3_broken.sql", 2, "parse"}, // missing semicolon
+	}
+
+	if len(issues) != len(wants) {
+		t.Fatalf("incorrect number of issues. got %v, want %v", issues, wants)
+	}
+	for i, w := range wants {
+		got := issues[i]
+		if filepath.Base(got.File) != w.file || got.Line != w.line || got.Rule != w.rule {
+			t.Errorf("incorrect issue. got %v, want %v", got, w)
+		}
+	}
+
+	if _, err := Lint(dir, map[string]Severity{"unknown": SeverityError}); err == nil {
+		t.Error("expected an error for an unknown rule")
+	}
+}
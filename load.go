@@ -0,0 +1,46 @@
+package mig
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrDatabaseNotEmpty the database already contains tables, views or routines
+var ErrDatabaseNotEmpty = errors.New("database is not empty")
+
+// loadSchema executes the statements of a schema dump against an empty
+// database, recreating its objects and recorded migration versions.
+func loadSchema(db *sql.DB, r io.Reader) error {
+	objects, err := listSchemaObjects(db)
+	if err != nil {
+		return err
+	}
+	if len(objects) > 0 {
+		return ErrDatabaseNotEmpty
+	}
+
+	stmts, err := splitSQLStatements(r, true)
+	if err != nil {
+		return fmt.Errorf("error reading schema dump: %v", err)
+	}
+
+	// session variables such as FOREIGN_KEY_CHECKS set by the dump
+	// must apply to every statement, so use a single connection
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, stmt := range stmts {
+		if _, err := conn.ExecContext(ctx, stmt.query); err != nil {
+			return fmt.Errorf("error loading schema: %v", err)
+		}
+	}
+
+	return nil
+}
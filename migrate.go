@@ -1,14 +1,19 @@
 package mig
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 var (
@@ -16,11 +21,68 @@ var (
 	ErrNoCurrentVersion = errors.New("no current version found")
 	// ErrNoNextVersion no next version
 	ErrNoNextVersion = errors.New("no next version found")
+	// ErrLockTimeout the migration lock could not be acquired in time
+	ErrLockTimeout = errors.New("timed out waiting for the migration lock")
+	// ErrDuplicateVersion two migration files share the same version
+	ErrDuplicateVersion = errors.New("duplicate migration version")
+	// ErrCorruptVersionTable the version table holds no applied version
+	ErrCorruptVersionTable = errors.New("version table has no applied version")
+	// ErrUnknownVersion versions applied to the database have no migration file
+	ErrUnknownVersion = errors.New("applied version unknown to the migration source")
 )
 
+// UnknownVersionError is returned when the database has versions applied
+// that no migration file of the source has, as when a binary starts against
+// a database migrated by a newer one. It matches ErrUnknownVersion with
+// errors.Is.
+type UnknownVersionError struct {
+	Versions []int64
+}
+
+func (e *UnknownVersionError) Error() string {
+	return fmt.Sprintf("mig: versions %v are applied to the database but unknown to the migration source", e.Versions)
+}
+
+// Is reports whether target is ErrUnknownVersion
+func (e *UnknownVersionError) Is(target error) bool {
+	return target == ErrUnknownVersion
+}
+
+// DuplicateVersionError is returned when two migration files share the
+// same version. It matches ErrDuplicateVersion with errors.Is.
+type DuplicateVersionError struct {
+	Version int64
+	Sources []string
+}
+
+func (e *DuplicateVersionError) Error() string {
+	return fmt.Sprintf("mig: duplicate version %v detected:\n%v", e.Version, strings.Join(e.Sources, "\n"))
+}
+
+// Is reports whether target is ErrDuplicateVersion
+func (e *DuplicateVersionError) Is(target error) bool {
+	return target == ErrDuplicateVersion
+}
+
 // Log log progress
 var Log io.Writer
 
+// IgnoreUnknown lets migrations run although the database has versions
+// applied that no migration file has, instead of failing with ErrUnknownVersion
+var IgnoreUnknown bool
+
+// Verbose logs each statement of a migration to Log as it starts executing
+var Verbose bool
+
+// VersionTable is the name of the table recording the applied versions.
+// The tables holding its metadata and the progress of batched statements
+// are named after it, with the _meta and _batches suffixes.
+var VersionTable = "mig_migrations"
+
+// LockTimeout is how long to wait for the migration lock held by
+// another mig process before giving up with ErrLockTimeout.
+var LockTimeout = 30 * time.Second
+
 func init() {
 	Log = ioutil.Discard
 }
@@ -34,25 +96,15 @@ func (e errNoMigration) Error() string {
 // IsNoMigrationError returns true if the error type is of
 // errNoMigration, indicating that there is no migration to run
 func IsNoMigrationError(err error) bool {
-	_, ok := err.(errNoMigration)
-	if ok {
-		return ok
-	}
-
-	return false
+	return errors.As(err, &errNoMigration{})
 }
 
 type migrations []*migration
 
 // helpers so we can use pkg sort
-func (m migrations) Len() int      { return len(m) }
-func (m migrations) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
-func (m migrations) Less(i, j int) bool {
-	if m[i].version == m[j].version {
-		panic(fmt.Sprintf("mig: duplicate version %v detected:\n%v\n%v", m[i].version, m[i].source, m[j].source))
-	}
-	return m[i].version < m[j].version
-}
+func (m migrations) Len() int           { return len(m) }
+func (m migrations) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m migrations) Less(i, j int) bool { return m[i].version < m[j].version }
 
 func (m migrations) current(current int64) (*migration, error) {
 	for i, migration := range m {
@@ -114,17 +166,24 @@ func collectMigrations(dirpath string, current, target int64) (migrations, error
 		}
 	}
 
-	migrations = sortAndConnectMigrations(migrations)
-
-	return migrations, nil
+	return sortAndConnectMigrations(migrations)
 }
 
 // sortAndConnectMigrations sorts the migrations based on the version numbers
 // and creates a linked list between each migration.
-func sortAndConnectMigrations(migrations migrations) migrations {
+func sortAndConnectMigrations(migrations migrations) (migrations, error) {
 	// Sort the migrations based on version
 	sort.Sort(migrations)
 
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i-1].version == migrations[i].version {
+			return nil, &DuplicateVersionError{
+				Version: migrations[i].version,
+				Sources: []string{migrations[i-1].source, migrations[i].source},
+			}
+		}
+	}
+
 	// now that we're sorted in the appropriate direction,
 	// populate next and previous for each migration
 	for i, m := range migrations {
@@ -136,7 +195,7 @@ func sortAndConnectMigrations(migrations migrations) migrations {
 		migrations[i].previous = prev
 	}
 
-	return migrations
+	return migrations, nil
 }
 
 // versionFilter returns true if v is within the current version and target
@@ -178,12 +237,133 @@ func createVersionTable(db *sql.DB) error {
 	return txn.Commit()
 }
 
+// getMetaVersion returns the schema version of the mig_migrations table
+// as recorded in mig_migrations_meta. Tables created before the metadata
+// table existed report version 0.
+func getMetaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow(getDialect().metaVersionQuery()).Scan(&version)
+	if isNoSuchTable(err) || err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return version, err
+}
+
+// ensureVersionTable creates the mig_migrations table if it doesn't exist
+// and applies any internal upgrade steps the table is missing, so that
+// databases migrated by older releases of mig keep working.
+func ensureVersionTable(db *sql.DB) error {
+	d := getDialect()
+	upgrades := d.versionTableUpgrades()
+
+	current, err := getMetaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current == len(upgrades) {
+		return nil
+	}
+
+	return withMigrationLock(db, func() error {
+		// another process may have upgraded the table while we waited
+		current, err := getMetaVersion(db)
+		if err != nil {
+			return err
+		}
+		if current == len(upgrades) {
+			return nil
+		}
+
+		rows, err := d.versionQuery(db)
+		if isNoSuchTable(err) {
+			if err := createVersionTable(db); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else {
+			rows.Close()
+		}
+
+		if current == 0 {
+			if _, err := db.Exec(d.createMetaTableSQL()); err != nil && !isTableExists(err) {
+				return fmt.Errorf("error creating metadata table: %v", err)
+			}
+		}
+
+		// DDL is not transactional in MySQL, so record each step as it
+		// completes. A step found already applied, by an upgrade interrupted
+		// before recording it or by hand, is recorded and skipped.
+		for i := current; i < len(upgrades); i++ {
+			if _, err := db.Exec(upgrades[i]); err != nil && !isAlreadyApplied(err) {
+				return fmt.Errorf("error upgrading version table to schema version %d: %v", i+1, err)
+			}
+			if _, err := db.Exec(d.upsertMetaVersionSQL(), i+1); err != nil {
+				return fmt.Errorf("error recording version table schema version %d: %v", i+1, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// withMigrationLock runs fn while holding the database wide migration lock,
+// waiting up to LockTimeout for it to be released by other mig processes.
+func withMigrationLock(db *sql.DB, fn func() error) error {
+	ctx := context.Background()
+	d := getDialect()
+
+	// the lock belongs to the session, so it must be taken and released
+	// on the same connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, d.lockSQL(), int(LockTimeout.Seconds())).Scan(&acquired); err != nil {
+		return fmt.Errorf("error acquiring migration lock: %v", err)
+	}
+	if acquired.Int64 != 1 {
+		return ErrLockTimeout
+	}
+	defer conn.ExecContext(ctx, d.unlockSQL())
+
+	return fn()
+}
+
+// isNoSuchTable reports whether err is MySQL's ER_NO_SUCH_TABLE.
+func isNoSuchTable(err error) bool {
+	e, ok := err.(*mysql.MySQLError)
+	return ok && e.Number == 1146
+}
+
+// isTableExists reports whether err is MySQL's ER_TABLE_EXISTS_ERROR.
+func isTableExists(err error) bool {
+	e, ok := err.(*mysql.MySQLError)
+	return ok && e.Number == 1050
+}
+
+// isAlreadyApplied reports whether err is MySQL's ER_TABLE_EXISTS_ERROR,
+// ER_DUP_FIELDNAME or ER_DUP_KEYNAME, returned by a version table upgrade
+// step that was already applied.
+func isAlreadyApplied(err error) bool {
+	e, ok := err.(*mysql.MySQLError)
+	return ok && (e.Number == 1050 || e.Number == 1060 || e.Number == 1061)
+}
+
 // getVersion retrieves the current version for this database.
 // Create and initialize the database migration table if it doesn't exist.
 func getVersion(db *sql.DB) (int64, error) {
+	if err := ensureVersionTable(db); err != nil {
+		return 0, err
+	}
+
 	rows, err := getDialect().versionQuery(db)
 	if err != nil {
-		return 0, createVersionTable(db)
+		return 0, err
 	}
 	defer rows.Close()
 
@@ -221,16 +401,91 @@ func getVersion(db *sql.DB) (int64, error) {
 		toSkip = append(toSkip, row.versionID)
 	}
 
-	panic("unreachable")
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// even the initial version 0 has been rolled back
+	return 0, ErrCorruptVersionTable
+}
+
+// unknownVersions returns the versions applied to the database
+// that no migration file of dir has.
+func unknownVersions(db *sql.DB, dir string) ([]int64, error) {
+	if err := ensureVersionTable(db); err != nil {
+		return nil, err
+	}
+
+	applied, err := getAppliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return unknownAmong(dir, applied)
+}
+
+// unknownAmong returns the versions of applied that have no migration file
+// in dir. The versions replaced by a squashed migration count as known.
+func unknownAmong(dir string, applied []int64) ([]int64, error) {
+	migrations, err := collectMigrations(dir, 0, math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+	known := map[int64]bool{0: true}
+	for _, m := range migrations {
+		known[m.version] = true
+
+		script, err := ioutil.ReadFile(m.source)
+		if err != nil {
+			return nil, err
+		}
+		squashed, err := squashedVersions(script)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Base(m.source), err)
+		}
+		for _, v := range squashed {
+			known[v] = true
+		}
+	}
+
+	var unknown []int64
+	for _, v := range applied {
+		if !known[v] {
+			unknown = append(unknown, v)
+		}
+	}
+
+	return unknown, nil
+}
+
+// checkUnknownVersions fails with an *UnknownVersionError when versions
+// applied to the database have no migration file, unless IgnoreUnknown is set.
+func checkUnknownVersions(db *sql.DB, dir string) error {
+	if IgnoreUnknown {
+		return nil
+	}
+
+	unknown, err := unknownVersions(db, dir)
+	if err != nil {
+		return err
+	}
+	if len(unknown) > 0 {
+		return &UnknownVersionError{Versions: unknown}
+	}
+
+	return nil
 }
 
-func getMigrationStatus(db *sql.DB, version int64) string {
+// getMigrationStatus returns when a version was applied, or Pending, along
+// with the checksum of its script recorded at the time, if any.
+func getMigrationStatus(db *sql.DB, version int64) (string, string, error) {
 	var row migrationRecord
-	q := fmt.Sprintf("SELECT tstamp, is_applied FROM mig_migrations WHERE version_id=%d ORDER BY tstamp DESC LIMIT 1", version)
-	e := db.QueryRow(q).Scan(&row.tstamp, &row.isApplied)
+	var sum sql.NullString
+	q := fmt.Sprintf("SELECT tstamp, is_applied, checksum FROM %s WHERE version_id=%d ORDER BY tstamp DESC, id DESC LIMIT 1", VersionTable, version)
+	e := db.QueryRow(q).Scan(&row.tstamp, &row.isApplied, &sum)
 
 	if e != nil && e != sql.ErrNoRows {
-		panic(e)
+		return "", "", e
 	}
 
 	var appliedAt string
@@ -241,5 +496,5 @@ func getMigrationStatus(db *sql.DB, version int64) string {
 		appliedAt = "Pending"
 	}
 
-	return appliedAt
+	return appliedAt, sum.String, nil
 }
@@ -1,7 +1,16 @@
 package mig
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 func newMigration(v int64, src string) *migration {
@@ -18,13 +27,35 @@ func TestMigrationSort(t *testing.T) {
 	ms = append(ms, newMigration(20129000, "test"))
 	ms = append(ms, newMigration(20127000, "test"))
 
-	ms = sortAndConnectMigrations(ms)
+	ms, err := sortAndConnectMigrations(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	sorted := []int64{20120000, 20127000, 20128000, 20129000}
 
 	validateMigrationSort(t, ms, sorted)
 }
 
+func TestMigrationSortDuplicate(t *testing.T) {
+
+	ms := migrations{}
+
+	ms = append(ms, newMigration(20120000, "20120000_a.sql"))
+	ms = append(ms, newMigration(20128000, "20128000_b.sql"))
+	ms = append(ms, newMigration(20120000, "20120000_c.sql"))
+
+	_, err := sortAndConnectMigrations(ms)
+	if !errors.Is(err, ErrDuplicateVersion) {
+		t.Fatalf("incorrect error. got %v, want %v", err, ErrDuplicateVersion)
+	}
+
+	var dup *DuplicateVersionError
+	if !errors.As(err, &dup) || dup.Version != 20120000 || len(dup.Sources) != 2 {
+		t.Errorf("incorrect duplicate version error. got %#v", err)
+	}
+}
+
 func validateMigrationSort(t *testing.T, ms migrations, sorted []int64) {
 
 	for i, m := range ms {
@@ -56,3 +87,126 @@ func validateMigrationSort(t *testing.T, ms migrations, sorted []int64) {
 
 	t.Log(ms)
 }
+
+func TestUnknownVersionError(t *testing.T) {
+
+	err := fmt.Errorf("up: %w", &UnknownVersionError{Versions: []int64{20170322104718}})
+
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Error("error is not ErrUnknownVersion")
+	}
+
+	want := "up: mig: versions [20170322104718] are applied to the database but unknown to the migration source"
+	if err.Error() != want {
+		t.Errorf("incorrect error.\ngot:  %s\nwant: %s", err, want)
+	}
+}
+
+// fakeDB is a database answering each query with handle, given the query
+// and its arguments, to test the statements mig runs without a server.
+type fakeDB struct {
+	handle func(query string, args []driver.NamedValue) ([][]driver.Value, error)
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, err := c.db.handle(query, args)
+	return driver.RowsAffected(0), err
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.db.handle(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct{ rows [][]driver.Value }
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return []string{"column"}
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestEnsureVersionTable(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		meta       int64 // schema version recorded, -1 if the metadata table is missing
+		stepErr    error // error of every upgrade step
+		wantMeta   int64
+		wantErr    bool
+		wantCreate bool
+	}{
+		{"legacy table", -1, nil, 3, false, true},
+		{"steps applied by hand", -1, &mysql.MySQLError{Number: 1061}, 3, false, true},
+		{"interrupted upgrade", 1, &mysql.MySQLError{Number: 1050}, 3, false, false},
+		{"column exists", 2, &mysql.MySQLError{Number: 1060}, 3, false, false},
+		{"up to date", 3, errors.New("unexpected upgrade"), 3, false, false},
+		{"failing step", 1, &mysql.MySQLError{Number: 1142}, 1, true, false},
+	}
+
+	for _, test := range tests {
+		meta, createdMeta := test.meta, false
+
+		db := sql.OpenDB(&fakeDB{handle: func(query string, args []driver.NamedValue) ([][]driver.Value, error) {
+			switch {
+			case strings.HasPrefix(query, "SELECT schema_version"):
+				if meta < 0 {
+					return nil, &mysql.MySQLError{Number: 1146}
+				}
+				return [][]driver.Value{{meta}}, nil
+			case strings.HasPrefix(query, "SELECT GET_LOCK"), strings.HasPrefix(query, "SELECT RELEASE_LOCK"):
+				return [][]driver.Value{{int64(1)}}, nil
+			case strings.HasPrefix(query, "SELECT version_id"):
+				return [][]driver.Value{{int64(0), true}}, nil
+			case strings.HasPrefix(query, "CREATE TABLE mig_migrations_meta"):
+				meta, createdMeta = 0, true
+				return nil, nil
+			case strings.HasPrefix(query, "INSERT INTO mig_migrations_meta"):
+				meta = args[0].Value.(int64)
+				return nil, nil
+			}
+			return nil, test.stepErr
+		}})
+
+		err := ensureVersionTable(db)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: incorrect error %v", test.name, err)
+		}
+		if meta != test.wantMeta {
+			t.Errorf("%s: incorrect schema version. got %v, want %v", test.name, meta, test.wantMeta)
+		}
+		if createdMeta != test.wantCreate {
+			t.Errorf("%s: metadata table created %v, want %v", test.name, createdMeta, test.wantCreate)
+		}
+		db.Close()
+	}
+}
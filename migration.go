@@ -3,16 +3,23 @@ package mig
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"text/template"
 	"time"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 type migrationRecord struct {
@@ -28,8 +35,54 @@ type migration struct {
 	source   string // path to .sql script
 }
 
+// sqlStatement is a single statement of a migration script along with
+// the '-- +mig' directives annotating it, such as "OnlineSchemaChange".
+type sqlStatement struct {
+	query      string
+	line       int // line of the script the statement starts on
+	directives []string
+}
+
+// directive returns the arguments of the named directive
+// and whether the statement is annotated with it.
+func (s sqlStatement) directive(name string) (string, bool) {
+	for _, d := range s.directives {
+		if d == name {
+			return "", true
+		}
+		if strings.HasPrefix(d, name+" ") {
+			return strings.TrimSpace(d[len(name):]), true
+		}
+	}
+
+	return "", false
+}
+
 const sqlCmdPrefix = "-- +mig "
 
+// knownDirectives are the '-- +mig' directives understood by mig
+var knownDirectives = map[string]bool{
+	"Up":                 true,
+	"Down":               true,
+	"StatementBegin":     true,
+	"StatementEnd":       true,
+	"OnlineSchemaChange": true,
+	"Batch":              true,
+	"Destructive":        true,
+	"Set":                true,
+}
+
+// splitError is an error splitting a migration script,
+// located at a line of the script.
+type splitError struct {
+	line int
+	msg  string
+}
+
+func (e *splitError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
 var migrationTemplate = template.Must(template.New("mig.sql-migration").Parse(`-- +mig Up
 
 -- +mig Down
@@ -37,7 +90,7 @@ var migrationTemplate = template.Must(template.New("mig.sql-migration").Parse(`-
 `))
 
 func (m *migration) String() string {
-	return fmt.Sprintf(m.source)
+	return m.source
 }
 
 func (m *migration) up(db *sql.DB) (string, error) {
@@ -49,8 +102,24 @@ func (m *migration) down(db *sql.DB) (string, error) {
 }
 
 func (m *migration) run(db *sql.DB, direction bool) (name string, err error) {
-	if err := runMigration(db, m.source, m.version, direction); err != nil {
-		return "", err
+	if direction {
+		if err := checkPartialSquash(db, m.source); err != nil {
+			return "", err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = runMigration(db, m.source, m.version, direction)
+		if err == nil {
+			break
+		}
+
+		wait, retry := Retry.retry(err, attempt)
+		if !retry {
+			return "", err
+		}
+		Log.Write([]byte(fmt.Sprintf("Retrying  %s in %v, attempt %d of %d: %v\n", filepath.Base(m.source), wait, attempt+1, Retry.MaxAttempts, err)))
+		time.Sleep(wait)
 	}
 
 	return filepath.Base(m.source), nil
@@ -80,10 +149,16 @@ func numericComponent(name string) (int64, error) {
 }
 
 // Update the version table for the given migration,
+// clear the progress of its batched statements,
 // and finalize the transaction.
-func finalizeMigration(tx *sql.Tx, direction bool, v int64) error {
-	stmt := getDialect().insertVersionSQL()
-	if _, err := tx.Exec(stmt, v, direction); err != nil {
+func finalizeMigration(tx *sql.Tx, direction bool, v int64, checksum string) error {
+	stmt := getDialect().insertChecksumVersionSQL()
+	if _, err := tx.Exec(stmt, v, direction, checksum); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec(getDialect().deleteBatchProgressSQL(), v, direction); err != nil {
 		tx.Rollback()
 		return err
 	}
@@ -118,12 +193,21 @@ func endsWithSemicolon(line string) bool {
 // within a statement. For these cases, we provide the explicit annotations
 // 'StatementBegin' and 'StatementEnd' to allow the script to
 // tell us to ignore semicolons.
-func splitSQLStatements(r io.Reader, direction bool) ([]string, error) {
+//
+// Any other '-- +mig' directive annotates the statement following it.
+func splitSQLStatements(r io.Reader, direction bool) ([]sqlStatement, error) {
 	var err error
-	var stmts []string
+	var stmts []sqlStatement
+	var directives []string
 	var buf bytes.Buffer
 	scanner := bufio.NewScanner(r)
 
+	// line numbers of the current line and of the first line
+	// of the current statement that isn't blank or a comment
+	lineNo := 0
+	stmtLine := 0
+	beginLine := 0
+
 	// track the count of each section
 	// so we can diagnose scripts with no annotations
 	upSections := 0
@@ -136,6 +220,7 @@ func splitSQLStatements(r io.Reader, direction bool) ([]string, error) {
 	for scanner.Scan() {
 
 		line := scanner.Text()
+		lineNo++
 
 		// handle any mig-specific commands
 		if strings.HasPrefix(line, sqlCmdPrefix) {
@@ -154,6 +239,7 @@ func splitSQLStatements(r io.Reader, direction bool) ([]string, error) {
 			case "StatementBegin":
 				if directionIsActive {
 					ignoreSemicolons = true
+					beginLine = lineNo
 				}
 				break
 
@@ -163,6 +249,11 @@ func splitSQLStatements(r io.Reader, direction bool) ([]string, error) {
 					ignoreSemicolons = false
 				}
 				break
+
+			default:
+				if directionIsActive {
+					directives = append(directives, cmd)
+				}
 			}
 		}
 
@@ -171,7 +262,11 @@ func splitSQLStatements(r io.Reader, direction bool) ([]string, error) {
 		}
 
 		if _, err := buf.WriteString(line + "\n"); err != nil {
-			panic(fmt.Sprintf("io err: %v", err))
+			return stmts, fmt.Errorf("io err: %v", err)
+		}
+
+		if trimmed := strings.TrimSpace(line); stmtLine == 0 && len(trimmed) > 0 && !strings.HasPrefix(trimmed, "--") {
+			stmtLine = lineNo
 		}
 
 		// Wrap up the two supported cases: 1) basic with semicolon; 2) psql statement
@@ -179,7 +274,12 @@ func splitSQLStatements(r io.Reader, direction bool) ([]string, error) {
 		// do not conclude statement.
 		if (!ignoreSemicolons && endsWithSemicolon(line)) || statementEnded {
 			statementEnded = false
-			stmts = append(stmts, buf.String())
+			if stmtLine == 0 {
+				stmtLine = lineNo
+			}
+			stmts = append(stmts, sqlStatement{query: buf.String(), line: stmtLine, directives: directives})
+			directives = nil
+			stmtLine = 0
 			buf.Reset()
 		}
 	}
@@ -190,20 +290,135 @@ func splitSQLStatements(r io.Reader, direction bool) ([]string, error) {
 
 	// diagnose likely migration script errors
 	if ignoreSemicolons {
-		return stmts, errors.New("saw '-- +mig StatementBegin' with no matching '-- +mig StatementEnd'")
+		return stmts, &splitError{line: beginLine, msg: "saw '-- +mig StatementBegin' with no matching '-- +mig StatementEnd'"}
 	}
 
-	if bufferRemaining := strings.TrimSpace(buf.String()); len(bufferRemaining) > 0 {
-		return stmts, fmt.Errorf("unexpected unfinished SQL query: %s. Missing a semicolon?", bufferRemaining)
+	// only comments left means a section without statements, not an unfinished one
+	if bufferRemaining := strings.TrimSpace(buf.String()); len(stripComments(bufferRemaining)) > 0 {
+		if stmtLine == 0 {
+			stmtLine = lineNo
+		}
+		return stmts, &splitError{line: stmtLine, msg: fmt.Sprintf("unexpected unfinished SQL query: %s. Missing a semicolon?", bufferRemaining)}
 	}
 
 	if upSections == 0 && downSections == 0 {
-		return stmts, fmt.Errorf(`no up/down annotations found, so no statements were executed`)
+		return stmts, &splitError{line: 1, msg: "no up/down annotations found, so no statements were executed"}
 	}
 
 	return stmts, err
 }
 
+// checksum returns the hex encoded SHA-256 of a migration script, recorded
+// in the version table to detect scripts changed after they were applied.
+func checksum(script []byte) string {
+	sum := sha256.Sum256(script)
+	return hex.EncodeToString(sum[:])
+}
+
+// Statements returns the statements of the Up section of a migration
+// file, or of its Down section if up is false, to preview them.
+func Statements(file string, up bool) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stmts, err := splitSQLStatements(f, up)
+	if err != nil {
+		return nil, err
+	}
+
+	var queries []string
+	for _, stmt := range stmts {
+		queries = append(queries, stmt.query)
+	}
+
+	return queries, nil
+}
+
+// MigrationError is returned when a migration script fails to apply.
+// It identifies the statement that failed, if any, and unwraps to the
+// underlying cause such as a *mysql.MySQLError.
+type MigrationError struct {
+	Version        int64
+	File           string
+	Direction      bool   // true when migrating up
+	StatementIndex int    // index of the failed statement in its section, -1 if no statement failed
+	Statement      string // text of the failed statement, without its leading comments
+	Line           int    // line of the failed statement in File, 0 if unknown
+	ErrorLine      int    // line of File MySQL reports the error at, such as a syntax error, 0 if unknown
+	Err            error
+
+	op        string // what was being done, such as executing or committing
+	committed bool   // statements of the migration may have been committed
+}
+
+func (e *MigrationError) Error() string {
+	op := e.op
+	if len(op) == 0 {
+		op = "executing"
+	}
+
+	if e.Line > 0 {
+		return fmt.Sprintf("error %s migration %s at line %d: %v", op, filepath.Base(e.File), e.Line, e.Err)
+	}
+	return fmt.Sprintf("error %s migration %s: %v", op, filepath.Base(e.File), e.Err)
+}
+
+// Unwrap returns the cause of the failure
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// leadingLines counts the blank and comment lines leading a statement.
+func leadingLines(query string) int {
+	lines := strings.Split(query, "\n")
+	n := 0
+	for n < len(lines) {
+		l := strings.TrimSpace(lines[n])
+		if len(l) > 0 && !strings.HasPrefix(l, "--") {
+			break
+		}
+		n++
+	}
+
+	return n
+}
+
+// statementText drops the blank and comment lines leading a statement,
+// so that its first line is the one reported by sqlStatement.line.
+func statementText(query string) string {
+	lines := strings.Split(query, "\n")[leadingLines(query):]
+	return strings.TrimRight(strings.Join(lines, "\n"), " \t\n")
+}
+
+// mysqlLineRegexp finds the line of a statement MySQL reports an error at
+var mysqlLineRegexp = regexp.MustCompile(`at line (\d+)$`)
+
+// errorLine returns the line of the script MySQL reports err at, counting
+// from the start of the statement text sent including its leading lines,
+// or 0 if err reports no line.
+func errorLine(stmt sqlStatement, err error) int {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return 0
+	}
+	m := mysqlLineRegexp.FindStringSubmatch(mysqlErr.Message)
+	if m == nil {
+		return 0
+	}
+	at, _ := strconv.Atoi(m[1])
+
+	// stmt.line is the line of the first line kept by statementText
+	line := stmt.line - leadingLines(stmt.query) + at - 1
+	if line < stmt.line {
+		return 0
+	}
+
+	return line
+}
+
 // runMigration runs a migration specified in raw SQL.
 //
 // Sections of the script can be annotated with a special comment,
@@ -212,20 +427,88 @@ func splitSQLStatements(r io.Reader, direction bool) ([]string, error) {
 //
 // All statements following an Up or Down directive are grouped together
 // until another direction directive is found.
+//
+// ALTER TABLE statements annotated with '-- +mig OnlineSchemaChange' are
+// handed to the OnlineSchemaChange tool outside of the transaction, and the
+// version is only recorded once its cut-over completes. Statements annotated
+// with '-- +mig Batch' are executed outside of the transaction as well, one
+// committed chunk of keys at a time.
+//
+// The statements run on a single connection, with the SessionVariables and
+// the variables of '-- +mig Set' directives set for the migration.
 func runMigration(db *sql.DB, scriptFile string, v int64, direction bool) error {
-	tx, err := db.Begin()
+	script, err := ioutil.ReadFile(scriptFile)
+	if err != nil {
+		return fmt.Errorf("cannot open migration file %s: %v", scriptFile, err)
+	}
+
+	// committed records whether statements may have been committed, by a
+	// DDL statement or before a statement run outside of the transaction,
+	// after which the migration cannot be retried from the start
+	committed := false
+
+	fail := func(op string, i int, stmt *sqlStatement, err error) error {
+		e := &MigrationError{Version: v, File: scriptFile, Direction: direction, StatementIndex: i, Err: err, op: op, committed: committed}
+		if stmt != nil {
+			e.Statement, e.Line, e.ErrorLine = statementText(stmt.query), stmt.line, errorLine(*stmt, err)
+		}
+		return e
+	}
+
+	stmts, err := splitSQLStatements(bytes.NewReader(script), direction)
 	if err != nil {
-		panic(err)
+		e := &MigrationError{Version: v, File: scriptFile, Direction: direction, StatementIndex: -1, Err: err, op: "splitting"}
+		if se, ok := err.(*splitError); ok {
+			e.Line, e.Err = se.line, errors.New(se.msg)
+		}
+		return e
 	}
 
-	f, err := os.Open(scriptFile)
+	vars, err := sessionVariables(script)
 	if err != nil {
-		return fmt.Errorf("cannot open migration file %s: %v", scriptFile, err)
+		e := &MigrationError{Version: v, File: scriptFile, Direction: direction, StatementIndex: -1, Err: err, op: "parsing"}
+		if se, ok := err.(*splitError); ok {
+			e.Line, e.Err = se.line, errors.New(se.msg)
+		}
+		return e
+	}
+
+	// throttle only while nothing is held: pausing inside the migration
+	// transaction would hold its locks on the primary for as long
+	if err := throttle(); err != nil {
+		return fail("starting", -1, nil, err)
+	}
+
+	// session variables must apply to every statement of the migration,
+	// so use a single connection, restoring them before it returns to the pool
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fail("starting", -1, nil, err)
 	}
+	defer conn.Close()
 
-	stmts, err := splitSQLStatements(f, direction)
+	restore, err := setSessionVariables(ctx, conn, vars)
 	if err != nil {
-		return fmt.Errorf("error splitting migration %s: %v", filepath.Base(scriptFile), err)
+		return fail("starting", -1, nil, err)
+	}
+	defer restore()
+
+	// an earlier, interrupted, run may have committed the statements preceding
+	// an online schema change or a batched statement, or completed it,
+	// resume after the last statement it committed
+	resumeAfter, err := completedStatements(ctx, conn, v, direction, stmts)
+	if err != nil {
+		return fail("starting", -1, nil, err)
+	}
+	if resumeAfter >= 0 {
+		committed = true
+		Log.Write([]byte(fmt.Sprintf("Resuming  %s after statement %d, completed by an earlier run\n", filepath.Base(scriptFile), resumeAfter+1)))
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fail("starting", -1, nil, err)
 	}
 
 	// find each statement, checking annotations for up/down direction
@@ -233,15 +516,78 @@ func runMigration(db *sql.DB, scriptFile string, v int64, direction bool) error
 	// Commits the transaction if successfully applied each statement and
 	// records the version into the version table or returns an error and
 	// rolls back the transaction.
-	for _, query := range stmts {
-		if _, err = tx.Exec(query); err != nil {
+	for i, stmt := range stmts {
+		if i <= resumeAfter {
+			continue
+		}
+
+		if Verbose {
+			Log.Write([]byte(fmt.Sprintf("Executing %s statement %d of %d at line %d\n", filepath.Base(scriptFile), i+1, len(stmts), stmt.line)))
+		}
+
+		_, isOnline := stmt.directive("OnlineSchemaChange")
+		options, isBatch := stmt.directive("Batch")
+
+		if isOnline || isBatch {
+			// commit the statements so far, so that the statement is not
+			// blocked by the locks held by the migration transaction,
+			// recording them as committed for a rerun to skip them
+			committed = true
+			if i > 0 && i-1 > resumeAfter {
+				if _, err = tx.Exec(getDialect().upsertBatchProgressSQL(), v, direction, i-1, statementCompleted); err != nil {
+					tx.Rollback()
+					return fail("committing", -1, nil, err)
+				}
+			}
+			if err = tx.Commit(); err != nil {
+				return fail("committing", -1, nil, err)
+			}
+
+			if err = throttle(); err != nil {
+				return fail("executing", i, &stmts[i], err)
+			}
+
+			if isOnline {
+				err = runOnlineSchemaChange(db, stmt.query)
+			} else {
+				err = runBatch(conn, v, direction, i, stmt.query, options)
+			}
+			if err == nil {
+				_, err = conn.ExecContext(ctx, getDialect().upsertBatchProgressSQL(), v, direction, i, statementCompleted)
+			}
+			if err != nil {
+				return fail("executing", i, &stmts[i], err)
+			}
+
+			if err = throttle(); err != nil {
+				return fail("executing", -1, nil, err)
+			}
+			if tx, err = conn.BeginTx(ctx, nil); err != nil {
+				return fail("starting", -1, nil, err)
+			}
+			continue
+		}
+
+		// MySQL implicitly commits the statements preceding a DDL statement,
+		// and the DDL statement itself
+		ddl := isDDL(stmt.query)
+		if ddl && i > 0 {
+			committed = true
+		}
+
+		if _, err = tx.Exec(stmt.query); err != nil {
 			tx.Rollback()
-			return fmt.Errorf("error executing migration %s: %v", filepath.Base(scriptFile), err)
+			return fail("executing", i, &stmts[i], err)
+		}
+		if ddl {
+			committed = true
 		}
 	}
 
-	if err = finalizeMigration(tx, direction, v); err != nil {
-		return fmt.Errorf("error committing migration %s: %v", filepath.Base(scriptFile), err)
+	// whether the commit succeeded is unknown when it fails
+	committed = true
+	if err = finalizeMigration(tx, direction, v, checksum(script)); err != nil {
+		return fail("committing", -1, nil, err)
 	}
 
 	return nil
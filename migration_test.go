@@ -1,8 +1,14 @@
 package mig
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 func TestSemicolons(t *testing.T) {
@@ -89,6 +95,129 @@ func TestSplitStatements(t *testing.T) {
 	}
 }
 
+func TestSplitStatementDirectives(t *testing.T) {
+
+	stmts, err := splitSQLStatements(strings.NewReader(directivetxt), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 2 {
+		t.Fatalf("incorrect number of stmts. got %v, want %v", len(stmts), 2)
+	}
+
+	if _, ok := stmts[0].directive("OnlineSchemaChange"); ok {
+		t.Error("unexpected directive on first statement")
+	}
+	if _, ok := stmts[1].directive("OnlineSchemaChange"); !ok {
+		t.Error("missing directive on second statement")
+	}
+
+	if stmts[0].line != 2 || stmts[1].line != 5 {
+		t.Errorf("incorrect statement lines. got %v and %v, want 2 and 5", stmts[0].line, stmts[1].line)
+	}
+}
+
+func TestMigrationError(t *testing.T) {
+
+	stmts, err := splitSQLStatements(strings.NewReader(directivetxt), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cause := &mysql.MySQLError{Number: 1060, Message: "Duplicate column name 'title'"}
+	err = &MigrationError{
+		Version:        2,
+		File:           "migrations/2_add_title.sql",
+		Direction:      true,
+		StatementIndex: 1,
+		Statement:      statementText(stmts[1].query),
+		Line:           stmts[1].line,
+		Err:            cause,
+	}
+
+	if got, want := err.Error(), "error executing migration 2_add_title.sql at line 5: Error 1060: Duplicate column name 'title'"; got != want {
+		t.Errorf("incorrect error. got %q, want %q", got, want)
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr != cause {
+		t.Error("error does not unwrap to its cause")
+	}
+
+	var me *MigrationError
+	if !errors.As(fmt.Errorf("up: %w", err), &me) {
+		t.Fatal("wrapped error is not a MigrationError")
+	}
+	if want := "ALTER TABLE post ADD COLUMN title text;"; me.Statement != want {
+		t.Errorf("incorrect statement. got %q, want %q", me.Statement, want)
+	}
+}
+
+func TestErrorLine(t *testing.T) {
+
+	stmts, err := splitSQLStatements(strings.NewReader(directivetxt), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second statement is sent with a blank and a directive line leading it
+	stmt := stmts[1]
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax near 'text' at line 3"}, 5},
+		{&mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax near '' at line 1"}, 0},
+		{&mysql.MySQLError{Number: 1060, Message: "Duplicate column name 'title'"}, 0},
+		{errors.New("at line 3"), 0},
+	}
+
+	for _, test := range tests {
+		if got := errorLine(stmt, test.err); got != test.want {
+			t.Errorf("errorLine(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestStatements(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "1_post.sql")
+	if err := ioutil.WriteFile(path, []byte(directivetxt), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	up, err := Statements(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	down, err := Statements(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(up) != 2 || len(down) != 2 {
+		t.Fatalf("incorrect number of statements. got %v up and %v down, want 2 and 2", len(up), len(down))
+	}
+	if want := "DROP TABLE post;"; strings.TrimSpace(down[1]) != want {
+		t.Errorf("incorrect statement. got %q, want %q", down[1], want)
+	}
+
+	if checksum([]byte(directivetxt)) == checksum([]byte(directivetxt+"\n")) {
+		t.Error("checksum does not change with the script")
+	}
+}
+
+var directivetxt = `-- +mig Up
+CREATE TABLE post (id int NOT NULL);
+
+-- +mig OnlineSchemaChange
+ALTER TABLE post ADD COLUMN title text;
+
+-- +mig Down
+ALTER TABLE post DROP COLUMN title;
+DROP TABLE post;
+`
+
 var functxt = `-- +mig Up
 CREATE TABLE IF NOT EXISTS histories (
   id                BIGSERIAL  PRIMARY KEY,
@@ -0,0 +1,115 @@
+package migtest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/satriahrh/mig"
+)
+
+// createDatabase creates a uniquely named mig_test_<random> database on the
+// server of dsn, and returns a connection pool to it. The database is dropped
+// once the test and its subtests complete, so tests running in parallel
+// against the same server do not collide.
+func createDatabase(t testing.TB, dsn string) *sql.DB {
+	t.Helper()
+
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("migtest: %v", err)
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatalf("migtest: %v", err)
+	}
+	name := "mig_test_" + hex.EncodeToString(suffix)
+
+	cfg.DBName = ""
+	server, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatalf("migtest: %v", err)
+	}
+
+	if _, err := server.Exec(fmt.Sprintf("CREATE DATABASE `%s`", name)); err != nil {
+		server.Close()
+		t.Fatalf("migtest: error creating database %s: %v", name, err)
+	}
+
+	// configured as mig configures its own, parsing the applied times
+	cfg.DBName = name
+	db, err := mig.Open(cfg.FormatDSN())
+	if err != nil {
+		server.Close()
+		t.Fatalf("migtest: %v", err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+		if _, err := server.Exec(fmt.Sprintf("DROP DATABASE `%s`", name)); err != nil {
+			t.Errorf("migtest: error dropping database %s: %v", name, err)
+		}
+		server.Close()
+	})
+
+	return db
+}
+
+// Database creates a uniquely named mig_test_<random> database on the server
+// of dsn, migrates it to the most recent version available in dir, and
+// returns a connection pool to it. The database in dsn is left untouched,
+// and the new database is dropped once the test completes.
+func Database(t testing.TB, dsn, dir string) *sql.DB {
+	t.Helper()
+
+	if err := mig.SetDialect(); err != nil {
+		t.Fatalf("migtest: %v", err)
+	}
+
+	db := createDatabase(t, dsn)
+	if _, err := mig.UpDB(db, dir); err != nil {
+		t.Fatalf("migtest: error migrating: %v", err)
+	}
+
+	return db
+}
+
+// Clone creates a uniquely named mig_test_<random> database on the server
+// of dsn holding the same schema and migration versions as template, an
+// already migrated database, and returns a connection pool to it. Loading
+// the schema of template is faster than replaying every migration, so
+// parallel subtests can share a template created once by their parent.
+// Rows inserted by data migrations are not copied.
+//
+//	template := migtest.Database(t, dsn, "../migrations")
+//	t.Run("a", func(t *testing.T) {
+//		t.Parallel()
+//		db := migtest.Clone(t, dsn, template)
+//		...
+//	})
+//
+// The new database is dropped once the test completes.
+func Clone(t testing.TB, dsn string, template *sql.DB) *sql.DB {
+	t.Helper()
+
+	if err := mig.SetDialect(); err != nil {
+		t.Fatalf("migtest: %v", err)
+	}
+
+	var dump bytes.Buffer
+	if err := mig.DumpSchemaDB(template, &dump); err != nil {
+		t.Fatalf("migtest: error dumping template: %v", err)
+	}
+
+	db := createDatabase(t, dsn)
+	if _, err := mig.LoadSchemaDB(db, &dump); err != nil {
+		t.Fatalf("migtest: error loading template: %v", err)
+	}
+
+	return db
+}
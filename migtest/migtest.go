@@ -0,0 +1,71 @@
+// Package migtest provides helpers for the tests of applications whose
+// database schema is managed by mig.
+//
+// Each helper migrates the given database and registers a cleanup rolling
+// every migration back once the test and its subtests complete, so that
+// tests sharing a database start from an empty schema:
+//
+//	func TestCreateUser(t *testing.T) {
+//		migtest.Fresh(t, db, "../migrations")
+//		...
+//	}
+//
+// Tests running in parallel against the same server can instead each get a
+// database of their own with Database, or Clone for a faster copy of an
+// already migrated template database.
+//
+// mig supports MySQL only, so db must be a MySQL connection pool opened
+// with the github.com/go-sql-driver/mysql driver.
+package migtest
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+
+	"github.com/satriahrh/mig"
+)
+
+// Fresh migrates db to the most recent version available in dir.
+func Fresh(t testing.TB, db *sql.DB, dir string) {
+	t.Helper()
+
+	At(t, db, dir, math.MaxInt64)
+}
+
+// At migrates db to the highest version available in dir not above
+// version, rolling back the migrations past it if db is already beyond.
+// Useful to test a data migration against the schema preceding it.
+func At(t testing.TB, db *sql.DB, dir string, version int64) {
+	t.Helper()
+
+	if err := mig.SetDialect(); err != nil {
+		t.Fatalf("migtest: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if _, err := mig.DownAllDB(db, dir); err != nil {
+			t.Errorf("migtest: error rolling back migrations: %v", err)
+		}
+	})
+
+	for {
+		current, err := mig.VersionDB(db)
+		if err != nil {
+			t.Fatalf("migtest: %v", err)
+		}
+		if current <= version {
+			break
+		}
+
+		if _, err := mig.DownDB(db, dir); mig.IsNoMigrationError(err) {
+			break
+		} else if err != nil {
+			t.Fatalf("migtest: error rolling back to version %d: %v", version, err)
+		}
+	}
+
+	if _, err := mig.UpToDB(db, dir, version); err != nil {
+		t.Fatalf("migtest: error migrating to version %d: %v", version, err)
+	}
+}
@@ -0,0 +1,102 @@
+package migtest
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/satriahrh/mig"
+)
+
+// testDB connects to the MySQL database named by MIG_TEST_DSN,
+// skipping the test when it is not set.
+func testDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv("MIG_TEST_DSN")
+	if len(dsn) == 0 {
+		t.Skip("MIG_TEST_DSN not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func writeMigrations(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"1_create_post.sql": "-- +mig Up\nCREATE TABLE post (id int NOT NULL);\n\n-- +mig Down\nDROP TABLE post;\n",
+		"2_add_title.sql":   "-- +mig Up\nALTER TABLE post ADD COLUMN title text;\n\n-- +mig Down\nALTER TABLE post DROP COLUMN title;\n",
+	}
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestAt(t *testing.T) {
+	db := testDB(t)
+	dir := writeMigrations(t)
+
+	t.Run("fresh", func(t *testing.T) {
+		Fresh(t, db, dir)
+
+		if v, err := mig.VersionDB(db); err != nil || v != 2 {
+			t.Fatalf("incorrect version. got %v (%v), want 2", v, err)
+		}
+	})
+
+	t.Run("at", func(t *testing.T) {
+		At(t, db, dir, 1)
+
+		if v, err := mig.VersionDB(db); err != nil || v != 1 {
+			t.Fatalf("incorrect version. got %v (%v), want 1", v, err)
+		}
+	})
+
+	if v, err := mig.VersionDB(db); err != nil || v != 0 {
+		t.Fatalf("migrations not rolled back. got version %v (%v), want 0", v, err)
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	db := testDB(t)
+	dir := writeMigrations(t)
+
+	template := Database(t, os.Getenv("MIG_TEST_DSN"), dir)
+	if v, err := mig.VersionDB(template); err != nil || v != 2 {
+		t.Fatalf("incorrect template version. got %v (%v), want 2", v, err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clone := Clone(t, os.Getenv("MIG_TEST_DSN"), template)
+			if v, err := mig.VersionDB(clone); err != nil || v != 2 {
+				t.Fatalf("incorrect clone version. got %v (%v), want 2", v, err)
+			}
+			// the applied times must be parsed
+			if _, err := mig.StatusDB(clone, dir); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := clone.Exec("INSERT INTO post (id, title) VALUES (1, 'hello')"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// the database of the DSN is not migrated
+	if v, err := mig.VersionDB(db); err != nil || v != 0 {
+		t.Fatalf("incorrect version. got %v (%v), want 0", v, err)
+	}
+}
@@ -0,0 +1,198 @@
+package mig
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Supported online schema change tools
+const (
+	GhOst                = "gh-ost"
+	PtOnlineSchemaChange = "pt-online-schema-change"
+)
+
+// OnlineSchemaChangeConfig configures the external tool running
+// online schema changes.
+type OnlineSchemaChangeConfig struct {
+	Tool string   // GhOst or PtOnlineSchemaChange
+	Path string   // path to the tool's executable, Tool is looked up in PATH if empty
+	Args []string // extra flags passed to the tool
+	DSN  string   // connection string of the migrated database, its address and credentials are passed to the tool, required
+}
+
+// OnlineSchemaChange configures the tool ALTER TABLE statements annotated
+// with '-- +mig OnlineSchemaChange' are handed to, instead of being executed
+// in the migration transaction.
+var OnlineSchemaChange = OnlineSchemaChangeConfig{Tool: GhOst}
+
+var alterTableRegexp = regexp.MustCompile("(?is)^ALTER\\s+TABLE\\s+(?:(`[^`]+`|\\w+)\\.)?(`[^`]+`|\\w+)\\s+(.+)$")
+
+// parseAlterTable breaks an ALTER TABLE statement down into its database,
+// which is empty if the table is not qualified, table and alterations.
+func parseAlterTable(query string) (database, table, alter string, err error) {
+	m := alterTableRegexp.FindStringSubmatch(stripComments(query))
+	if m == nil {
+		return "", "", "", fmt.Errorf("online schema change requires an ALTER TABLE statement")
+	}
+
+	unquote := func(name string) string { return strings.Trim(name, "`") }
+	return unquote(m[1]), unquote(m[2]), m[3], nil
+}
+
+// writeOptionFile writes the user and password of cfg to a MySQL option file
+// readable only by the current user, so that the password is not passed to
+// the tool on its command line, visible in process listings.
+func writeOptionFile(cfg *mysql.Config) (string, error) {
+	f, err := ioutil.TempFile("", "mig-osc-*.cnf")
+	if err != nil {
+		return "", err
+	}
+
+	// quoted, so that characters such as '#' are not read as comments
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	_, err = fmt.Fprintf(f, "[client]\nuser=\"%s\"\npassword=\"%s\"\n", quote.Replace(cfg.User), quote.Replace(cfg.Passwd))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// onlineSchemaChangeCommand builds the command running the configured
+// tool to apply alter to database.table. The address of OnlineSchemaChange.DSN
+// is passed as arguments, and its credentials through an option file removed
+// by cleanup once the command completes.
+func onlineSchemaChangeCommand(database, table, alter string) (cmd *exec.Cmd, cleanup func(), err error) {
+	path := OnlineSchemaChange.Path
+	if len(path) == 0 {
+		path = OnlineSchemaChange.Tool
+	}
+
+	// without an address the tool would fall back to its own defaults,
+	// altering whichever server those point to
+	if len(OnlineSchemaChange.DSN) == 0 {
+		return nil, nil, fmt.Errorf("no connection string for %s, OnlineSchemaChange.DSN is not set", OnlineSchemaChange.Tool)
+	}
+	if OnlineSchemaChange.Tool != GhOst && OnlineSchemaChange.Tool != PtOnlineSchemaChange {
+		return nil, nil, fmt.Errorf("unsupported online schema change tool %q", OnlineSchemaChange.Tool)
+	}
+
+	cfg, err := mysql.ParseDSN(OnlineSchemaChange.DSN)
+	if err != nil {
+		return nil, nil, err
+	}
+	var host, port string
+	if cfg.Net == "tcp" {
+		if host, port, err = net.SplitHostPort(cfg.Addr); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	optionFile, err := writeOptionFile(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup = func() { os.Remove(optionFile) }
+
+	var args []string
+	if OnlineSchemaChange.Tool == GhOst {
+		if cfg.Net == "tcp" {
+			args = append(args, "--host="+host, "--port="+port)
+		}
+		args = append(args, "--conf="+optionFile, "--database="+database, "--table="+table, "--alter="+alter, "--execute")
+	} else {
+		dsn := fmt.Sprintf("h=%s,P=%s,F=%s,D=%s,t=%s", host, port, optionFile, database, table)
+		if cfg.Net == "unix" {
+			dsn = fmt.Sprintf("S=%s,F=%s,D=%s,t=%s", cfg.Addr, optionFile, database, table)
+		}
+		args = []string{"--alter", alter, dsn, "--execute"}
+	}
+
+	return exec.Command(path, append(args, OnlineSchemaChange.Args...)...), cleanup, nil
+}
+
+// runOnlineSchemaChange hands an ALTER TABLE statement to the configured
+// online schema change tool, streaming its output to Log. It returns once
+// the tool has completed the cut-over.
+func runOnlineSchemaChange(db *sql.DB, query string) error {
+	database, table, alter, err := parseAlterTable(query)
+	if err != nil {
+		return err
+	}
+
+	if len(database) == 0 {
+		if err := db.QueryRow("SELECT DATABASE()").Scan(&database); err != nil {
+			return err
+		}
+	}
+
+	cmd, cleanup, err := onlineSchemaChangeCommand(database, table, alter)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	out := &prefixWriter{w: Log, prefix: OnlineSchemaChange.Tool + ": "}
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	err = cmd.Run()
+	out.Flush()
+	if err != nil {
+		return fmt.Errorf("%s failed on table %s: %v", OnlineSchemaChange.Tool, table, err)
+	}
+
+	return nil
+}
+
+// prefixWriter writes each complete line written to it to w with a prefix.
+type prefixWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    bytes.Buffer
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.buf.Write(b)
+	for {
+		i := bytes.IndexByte(p.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+
+		line := p.buf.Next(i + 1)
+		if _, err := p.w.Write(append([]byte(p.prefix), line...)); err != nil {
+			return len(b), err
+		}
+	}
+}
+
+// Flush writes any incomplete line left.
+func (p *prefixWriter) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.buf.Len() > 0 {
+		p.w.Write(append([]byte(p.prefix), append(p.buf.Bytes(), '\n')...))
+		p.buf.Reset()
+	}
+}
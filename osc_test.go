@@ -0,0 +1,173 @@
+package mig
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseAlterTable(t *testing.T) {
+
+	type testData struct {
+		query    string
+		database string
+		table    string
+		alter    string
+	}
+
+	tests := []testData{
+		{
+			query: "-- +mig OnlineSchemaChange\nALTER TABLE orders ADD COLUMN status_v2 int;\n",
+			table: "orders",
+			alter: "ADD COLUMN status_v2 int",
+		},
+		{
+			query:    "alter table `shop`.`orders`\n  ADD INDEX status_idx (status),\n  DROP COLUMN notes;",
+			database: "shop",
+			table:    "orders",
+			alter:    "ADD INDEX status_idx (status),\n  DROP COLUMN notes",
+		},
+	}
+
+	for _, test := range tests {
+		database, table, alter, err := parseAlterTable(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if database != test.database || table != test.table || alter != test.alter {
+			t.Errorf("incorrect alter table. got %q %q %q, want %q %q %q", database, table, alter, test.database, test.table, test.alter)
+		}
+	}
+
+	if _, _, _, err := parseAlterTable("UPDATE orders SET status = 1;"); err == nil {
+		t.Error("expected an error for a statement other than ALTER TABLE")
+	}
+}
+
+func TestRunOnlineSchemaChange(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "mig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// fake gh-ost echoing its arguments
+	fake := filepath.Join(dir, "gh-ost")
+	script := "#!/bin/sh\nfor arg in \"$@\"; do echo \"$arg\"; done\nprintf 'Done'\n"
+	if err := ioutil.WriteFile(fake, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(config OnlineSchemaChangeConfig) {
+		OnlineSchemaChange = config
+		Log = ioutil.Discard
+	}(OnlineSchemaChange)
+
+	var buf bytes.Buffer
+	Log = &buf
+	OnlineSchemaChange = OnlineSchemaChangeConfig{Tool: GhOst, Path: fake, Args: []string{"--max-load=Threads_running=25"}}
+
+	// the tool would alter whichever server its defaults point to
+	if err := runOnlineSchemaChange(nil, "ALTER TABLE shop.orders ADD COLUMN x int;"); err == nil {
+		t.Error("expected an error without a connection string")
+	}
+
+	OnlineSchemaChange.DSN = "admin:secret@tcp(db:3306)/shop"
+	if err := runOnlineSchemaChange(nil, "ALTER TABLE shop.orders ADD COLUMN x int;"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"gh-ost: --host=db",
+		"gh-ost: --port=3306",
+		"gh-ost: --conf=OPTIONS",
+		"gh-ost: --database=shop",
+		"gh-ost: --table=orders",
+		"gh-ost: --alter=ADD COLUMN x int",
+		"gh-ost: --execute",
+		"gh-ost: --max-load=Threads_running=25",
+		"gh-ost: Done",
+		"",
+	}, "\n")
+	if got := regexp.MustCompile(`--conf=\S+`).ReplaceAllString(buf.String(), "--conf=OPTIONS"); got != want {
+		t.Errorf("incorrect output. got %q, want %q", got, want)
+	}
+
+	// a failing tool fails the migration
+	if err := ioutil.WriteFile(fake, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := runOnlineSchemaChange(nil, "ALTER TABLE shop.orders ADD COLUMN x int;"); err == nil {
+		t.Error("expected an error from a failing tool")
+	}
+}
+
+func TestOnlineSchemaChangeCredentials(t *testing.T) {
+
+	defer func(config OnlineSchemaChangeConfig) { OnlineSchemaChange = config }(OnlineSchemaChange)
+
+	tests := []struct {
+		tool string
+		args []string // arguments, with the option file as %s
+	}{
+		{GhOst, []string{"--host=db", "--port=3306", "--conf=%s", "--database=shop", "--table=orders", "--alter=ADD COLUMN x int", "--execute"}},
+		{PtOnlineSchemaChange, []string{"--alter", "ADD COLUMN x int", "h=db,P=3306,F=%s,D=shop,t=orders", "--execute"}},
+	}
+
+	for _, test := range tests {
+		OnlineSchemaChange = OnlineSchemaChangeConfig{Tool: test.tool, DSN: `admin:s3cr#t"pw@tcp(db:3306)/shop`}
+
+		cmd, cleanup, err := onlineSchemaChangeCommand("shop", "orders", "ADD COLUMN x int")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var optionFile string
+		for _, arg := range cmd.Args {
+			if strings.Contains(arg, "s3cr") {
+				t.Errorf("%s: password passed on the command line: %q", test.tool, arg)
+			}
+			if i := strings.Index(arg, os.TempDir()); i >= 0 {
+				optionFile = strings.SplitN(arg[i:], ",", 2)[0]
+			}
+		}
+
+		var want []string
+		for _, arg := range test.args {
+			if strings.Contains(arg, "%s") {
+				arg = fmt.Sprintf(arg, optionFile)
+			}
+			want = append(want, arg)
+		}
+		if !reflect.DeepEqual(cmd.Args[1:], want) {
+			t.Errorf("%s: incorrect arguments.\ngot:  %q\nwant: %q", test.tool, cmd.Args[1:], want)
+		}
+
+		fi, err := os.Stat(optionFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Errorf("%s: option file readable by others: %v", test.tool, fi.Mode())
+		}
+		b, err := ioutil.ReadFile(optionFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "[client]\nuser=\"admin\"\npassword=\"s3cr#t\\\"pw\"\n"; string(b) != want {
+			t.Errorf("%s: incorrect option file. got %q, want %q", test.tool, b, want)
+		}
+
+		cleanup()
+		if _, err := os.Stat(optionFile); !os.IsNotExist(err) {
+			t.Errorf("%s: option file not removed", test.tool)
+		}
+	}
+}
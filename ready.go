@@ -0,0 +1,213 @@
+package mig
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNotUpToDate the database is not at the most recent version available
+var ErrNotUpToDate = errors.New("database schema is not up to date")
+
+// NotUpToDateError is returned by CheckUpToDate with the reasons the
+// database is not at the most recent version available. It matches
+// ErrNotUpToDate with errors.Is.
+type NotUpToDateError struct {
+	Version     int64    // current version of the database
+	Latest      int64    // most recent version available
+	Pending     []string // migrations not applied yet
+	Interrupted []int64  // versions whose batched statements were interrupted
+	Unknown     []int64  // versions applied that have no migration file
+}
+
+func (e *NotUpToDateError) Error() string {
+	var reasons []string
+	if e.Version > e.Latest {
+		reasons = append(reasons, fmt.Sprintf("database at version %d is ahead of the most recent migration %d", e.Version, e.Latest))
+	}
+	if len(e.Pending) > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d pending migrations: %s", len(e.Pending), strings.Join(e.Pending, ", ")))
+	}
+	if len(e.Unknown) > 0 {
+		reasons = append(reasons, fmt.Sprintf("versions %v unknown to the migration source", e.Unknown))
+	}
+	if len(e.Interrupted) > 0 {
+		reasons = append(reasons, fmt.Sprintf("interrupted batches of versions %v", e.Interrupted))
+	}
+
+	return fmt.Sprintf("mig: database schema is not up to date: %s", strings.Join(reasons, "; "))
+}
+
+// Is reports whether target is ErrNotUpToDate
+func (e *NotUpToDateError) Is(target error) bool {
+	return target == ErrNotUpToDate
+}
+
+// getInterruptedBatches returns the versions whose batched statements
+// were interrupted before completing. A version table predating batches
+// has none.
+func getInterruptedBatches(ctx context.Context, db *sql.DB) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, getDialect().interruptedBatchesQuery())
+	if isNoSuchTable(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []int64
+	for rows.Next() {
+		var v int64
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+
+	return versions, rows.Err()
+}
+
+// readVersions returns the current version of the database and every
+// version applied to it in ascending order, without creating or upgrading
+// the version table: a database without one is at version 0.
+func readVersions(ctx context.Context, db *sql.DB) (int64, []int64, error) {
+	rows, err := db.QueryContext(ctx, getDialect().versionRecordsQuery())
+	if isNoSuchTable(err) {
+		return 0, nil, nil
+	} else if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	// the most recent record for each version tells whether it is applied,
+	// the most recent version applied is the current version
+	current := int64(-1)
+	seen := map[int64]bool{}
+	var applied []int64
+	for rows.Next() {
+		var row migrationRecord
+		if err := rows.Scan(&row.versionID, &row.isApplied); err != nil {
+			return 0, nil, fmt.Errorf("error scanning rows: %s", err)
+		}
+
+		if seen[row.versionID] {
+			continue
+		}
+		seen[row.versionID] = true
+
+		if row.isApplied {
+			applied = append(applied, row.versionID)
+			if current < 0 {
+				current = row.versionID
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	if current < 0 {
+		return 0, nil, ErrCorruptVersionTable
+	}
+
+	sort.Slice(applied, func(i, j int) bool { return applied[i] < applied[j] })
+	return current, applied, nil
+}
+
+// ReadVersion returns the current version of the database as VersionDB does,
+// but without creating, upgrading or locking the version table, so that it
+// runs with read only privileges and alongside a migrating process. A
+// database without a version table is at version 0.
+// Expects SetDialect to be called beforehand
+func ReadVersion(ctx context.Context, db *sql.DB) (int64, error) {
+	current, _, err := readVersions(ctx, db)
+	return current, err
+}
+
+// LatestVersion returns the version of the most recent migration of dir,
+// or 0 if dir has no migrations.
+func LatestVersion(dir string) (int64, error) {
+	migrations, err := collectMigrations(dir, 0, math.MaxInt64)
+	if err != nil {
+		return 0, err
+	}
+
+	last, err := migrations.last()
+	if err == ErrNoNextVersion {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return last.version, nil
+}
+
+// CheckUpToDate returns a *NotUpToDateError when migrations of dir are
+// pending, the database is ahead of the most recent migration or has
+// versions applied unknown to dir, or batched statements were interrupted,
+// and nil when the database is up to date. It only reads the database, a
+// database without a version table is at version 0.
+// Expects SetDialect to be called beforehand
+func CheckUpToDate(ctx context.Context, db *sql.DB, dir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	migrations, err := collectMigrations(dir, 0, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+
+	e := &NotUpToDateError{}
+	var applied []int64
+	if e.Version, applied, err = readVersions(ctx, db); err != nil {
+		return err
+	}
+	if last, err := migrations.last(); err == nil {
+		e.Latest = last.version
+	}
+
+	isApplied := map[int64]bool{}
+	for _, v := range applied {
+		isApplied[v] = true
+	}
+	for _, m := range migrations {
+		if !isApplied[m.version] {
+			e.Pending = append(e.Pending, filepath.Base(m.source))
+		}
+	}
+
+	if e.Unknown, err = unknownAmong(dir, applied); err != nil {
+		return err
+	}
+
+	if e.Interrupted, err = getInterruptedBatches(ctx, db); err != nil {
+		return err
+	}
+
+	if e.Version > e.Latest || len(e.Pending) > 0 || len(e.Unknown) > 0 || len(e.Interrupted) > 0 {
+		return e
+	}
+
+	return nil
+}
+
+// ReadinessHandler answers 200 when the database is up to date with the
+// migrations of dir and 503 otherwise, with the reason as JSON, to be
+// used as a readiness probe.
+// Expects SetDialect to be called beforehand
+func ReadinessHandler(db *sql.DB, dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := CheckUpToDate(r.Context(), db, dir); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"ready": false, "error": err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ready": true})
+	})
+}
@@ -0,0 +1,122 @@
+package mig
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestNotUpToDateError(t *testing.T) {
+
+	err := error(&NotUpToDateError{
+		Version:     2,
+		Latest:      3,
+		Pending:     []string{"3_add_title.sql"},
+		Interrupted: []int64{2},
+	})
+
+	if !errors.Is(err, ErrNotUpToDate) {
+		t.Error("error is not ErrNotUpToDate")
+	}
+
+	want := "mig: database schema is not up to date: 1 pending migrations: 3_add_title.sql; interrupted batches of versions [2]"
+	if err.Error() != want {
+		t.Errorf("incorrect error.\ngot:  %s\nwant: %s", err, want)
+	}
+
+	err = &NotUpToDateError{Version: 4, Latest: 3}
+	want = "mig: database schema is not up to date: database at version 4 is ahead of the most recent migration 3"
+	if err.Error() != want {
+		t.Errorf("incorrect error.\ngot:  %s\nwant: %s", err, want)
+	}
+}
+
+func TestCheckUpToDateCancelled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := CheckUpToDate(ctx, nil, "."); !errors.Is(err, context.Canceled) {
+		t.Errorf("incorrect error. got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestCheckUpToDateReadOnly(t *testing.T) {
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"1_create_post.sql": "-- +mig Up\nCREATE TABLE post (id int NOT NULL);\n",
+		"2_add_title.sql":   "-- +mig Up\nALTER TABLE post ADD COLUMN title text;\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		records [][]driver.Value // version table rows, most recent first, nil if missing
+		want    *NotUpToDateError
+	}{
+		{"no version table", nil, &NotUpToDateError{Latest: 2, Pending: []string{"1_create_post.sql", "2_add_title.sql"}}},
+		{"pending", [][]driver.Value{{int64(2), false}, {int64(2), true}, {int64(1), true}, {int64(0), true}}, &NotUpToDateError{Version: 1, Latest: 2, Pending: []string{"2_add_title.sql"}}},
+		{"unknown", [][]driver.Value{{int64(3), true}, {int64(2), true}, {int64(1), true}, {int64(0), true}}, &NotUpToDateError{Version: 3, Latest: 2, Unknown: []int64{3}}},
+		{"up to date", [][]driver.Value{{int64(2), true}, {int64(1), true}, {int64(0), true}}, nil},
+	}
+
+	for _, test := range tests {
+		db := sql.OpenDB(&fakeDB{handle: func(query string, args []driver.NamedValue) ([][]driver.Value, error) {
+			switch {
+			case strings.HasPrefix(query, "SELECT version_id, is_applied FROM mig_migrations "):
+				if test.records == nil {
+					return nil, &mysql.MySQLError{Number: 1146}
+				}
+				return test.records, nil
+			case strings.HasPrefix(query, "SELECT DISTINCT version_id FROM mig_migrations_batches"):
+				return nil, &mysql.MySQLError{Number: 1146}
+			}
+			t.Errorf("%s: unexpected query %s", test.name, query)
+			return nil, errors.New("unexpected query")
+		}})
+
+		err := CheckUpToDate(context.Background(), db, dir)
+		db.Close()
+
+		if test.want == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+		var got *NotUpToDateError
+		if !errors.As(err, &got) || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: incorrect error. got %#v, want %#v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestLatestVersion(t *testing.T) {
+
+	dir := t.TempDir()
+	if latest, err := LatestVersion(dir); err != nil || latest != 0 {
+		t.Errorf("empty dir: got %v, %v, want 0", latest, err)
+	}
+
+	for _, name := range []string{"1_create_post.sql", "20170314221501_add_title.sql"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("-- +mig Up\nSELECT 1;\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if latest, err := LatestVersion(dir); err != nil || latest != 20170314221501 {
+		t.Errorf("got %v, %v, want 20170314221501", latest, err)
+	}
+}
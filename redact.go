@@ -0,0 +1,26 @@
+package mig
+
+import "strings"
+
+// RedactDSN returns the connection string with its password masked,
+// so that it can be logged or printed.
+func RedactDSN(dsn string) string {
+	// the password sits between the first ':' and the last '@'
+	// preceding the last '/', as parsed by the mysql driver
+	slash := strings.LastIndex(dsn, "/")
+	if slash < 0 {
+		slash = len(dsn)
+	}
+
+	at := strings.LastIndex(dsn[:slash], "@")
+	if at < 0 {
+		return dsn
+	}
+
+	colon := strings.Index(dsn[:at], ":")
+	if colon < 0 {
+		return dsn
+	}
+
+	return dsn[:colon+1] + "xxxxx" + dsn[at:]
+}
@@ -0,0 +1,21 @@
+package mig
+
+import "testing"
+
+func TestRedactDSN(t *testing.T) {
+
+	tests := map[string]string{
+		"user:secret@tcp(localhost:3306)/db":             "user:xxxxx@tcp(localhost:3306)/db",
+		"user:p@ss:w/rd@tcp(localhost:3306)/db?tls=true": "user:xxxxx@tcp(localhost:3306)/db?tls=true",
+		"user@tcp(localhost:3306)/db":                    "user@tcp(localhost:3306)/db",
+		"user:secret@tcp(localhost:3306)":                "user:xxxxx@tcp(localhost:3306)",
+		"/db":                                            "/db",
+		"not a dsn":                                      "not a dsn",
+	}
+
+	for dsn, want := range tests {
+		if got := RedactDSN(dsn); got != want {
+			t.Errorf("incorrect redaction of %q. got %q, want %q", dsn, got, want)
+		}
+	}
+}
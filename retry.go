@@ -0,0 +1,76 @@
+package mig
+
+import (
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// RetryConfig configures the retrying of migrations failing
+// with transient errors, such as deadlocks.
+type RetryConfig struct {
+	MaxAttempts int              // attempts of a migration, retrying is disabled if 1 or less
+	MinBackoff  time.Duration    // wait before the first retry, doubled on every following retry
+	MaxBackoff  time.Duration    // longest wait between retries
+	Retryable   func(error) bool // reports whether an error is transient, IsRetryable if nil
+}
+
+// Retry configures the retrying of migrations failing with transient errors.
+// A migration is only retried while none of its statements may have been
+// committed: MySQL implicitly commits DDL statements, and the statements
+// preceding those annotated with OnlineSchemaChange or Batch are committed
+// before they run, so migrations failing after such statements are not retried.
+var Retry = RetryConfig{
+	MaxAttempts: 3,
+	MinBackoff:  time.Second,
+	MaxBackoff:  30 * time.Second,
+}
+
+// IsRetryable reports whether err is transient: a deadlock, a lock wait
+// timeout or a lost connection.
+func IsRetryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1205, // ER_LOCK_WAIT_TIMEOUT
+			1213: // ER_LOCK_DEADLOCK
+			return true
+		}
+		return false
+	}
+
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn)
+}
+
+// retry reports whether a migration failing with err on the given attempt
+// is retried, and how long to wait before retrying it.
+func (c RetryConfig) retry(err error, attempt int) (time.Duration, bool) {
+	if attempt >= c.MaxAttempts {
+		return 0, false
+	}
+
+	var migrationErr *MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.committed {
+		return 0, false
+	}
+
+	retryable := c.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	if !retryable(migrationErr.Err) {
+		return 0, false
+	}
+
+	wait := c.MinBackoff
+	for i := 1; i < attempt && wait < c.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > c.MaxBackoff {
+		wait = c.MaxBackoff
+	}
+
+	return wait, true
+}
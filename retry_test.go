@@ -0,0 +1,68 @@
+package mig
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestIsRetryable(t *testing.T) {
+
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}, true},
+		{&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, true},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, false},
+		{driver.ErrBadConn, true},
+		{mysql.ErrInvalidConn, true},
+		{fmt.Errorf("chunk 3: %w", &mysql.MySQLError{Number: 1213}), true},
+		{errors.New("syntax error"), false},
+	}
+
+	for _, test := range tests {
+		if got := IsRetryable(test.err); got != test.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestRetryConfigRetry(t *testing.T) {
+
+	config := RetryConfig{MaxAttempts: 5, MinBackoff: time.Second, MaxBackoff: 3 * time.Second}
+	deadlock := &MigrationError{Err: &mysql.MySQLError{Number: 1213}}
+
+	// backoff doubles up to MaxBackoff
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		wait, ok := config.retry(deadlock, attempt+1)
+		if !ok || wait != want {
+			t.Errorf("attempt %d: got %v, %v, want %v, true", attempt+1, wait, ok, want)
+		}
+	}
+
+	// no attempts left
+	if _, ok := config.retry(deadlock, 5); ok {
+		t.Error("retried past MaxAttempts")
+	}
+
+	// statements may have been committed
+	if _, ok := config.retry(&MigrationError{Err: deadlock.Err, committed: true}, 1); ok {
+		t.Error("retried a migration with committed statements")
+	}
+
+	// permanent error
+	if _, ok := config.retry(&MigrationError{Err: errors.New("syntax error")}, 1); ok {
+		t.Error("retried a permanent error")
+	}
+
+	// custom classification
+	config.Retryable = func(error) bool { return true }
+	if _, ok := config.retry(&MigrationError{Err: errors.New("syntax error")}, 1); !ok {
+		t.Error("Retryable not used")
+	}
+}
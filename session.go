@@ -0,0 +1,131 @@
+package mig
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SessionVariables are MySQL session variables, such as lock_wait_timeout or
+// sql_mode, set on the connection each migration runs on. Values are SQL
+// expressions, so strings must be quoted. The '-- +mig Set name=value'
+// directives of a migration file override them for that migration, and the
+// previous values are restored once the migration completes.
+var SessionVariables = map[string]string{}
+
+// sessionVariable is a session variable set for a migration.
+type sessionVariable struct {
+	name  string
+	value string
+}
+
+var sessionVariableRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseSetDirective parses the argument of a '-- +mig Set' directive,
+// such as
+//
+//	-- +mig Set lock_wait_timeout=5
+func parseSetDirective(arg string) (sessionVariable, error) {
+	i := strings.Index(arg, "=")
+	if i < 0 {
+		return sessionVariable{}, fmt.Errorf("invalid Set directive %q, expected name=value", arg)
+	}
+
+	v := sessionVariable{name: strings.TrimSpace(arg[:i]), value: strings.TrimSpace(arg[i+1:])}
+	if !sessionVariableRegexp.MatchString(v.name) {
+		return sessionVariable{}, fmt.Errorf("invalid session variable name %q", v.name)
+	}
+	if len(v.value) == 0 {
+		return sessionVariable{}, fmt.Errorf("no value for session variable %s", v.name)
+	}
+
+	return v, nil
+}
+
+// sessionVariables returns the session variables of a migration script,
+// SessionVariables overridden by the Set directives found anywhere in the
+// script. They apply to both its Up and Down sections.
+func sessionVariables(script []byte) ([]sessionVariable, error) {
+	var vars []sessionVariable
+	index := map[string]int{}
+
+	set := func(v sessionVariable) {
+		key := strings.ToLower(v.name)
+		if i, ok := index[key]; ok {
+			vars[i] = v
+			return
+		}
+		index[key] = len(vars)
+		vars = append(vars, v)
+	}
+
+	names := make([]string, 0, len(SessionVariables))
+	for name := range SessionVariables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v, err := parseSetDirective(name + "=" + SessionVariables[name])
+		if err != nil {
+			return nil, err
+		}
+		set(v)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(script))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, sqlCmdPrefix+"Set ") {
+			continue
+		}
+
+		v, err := parseSetDirective(line[len(sqlCmdPrefix+"Set "):])
+		if err != nil {
+			return nil, &splitError{line: lineNo, msg: err.Error()}
+		}
+		set(v)
+	}
+
+	return vars, scanner.Err()
+}
+
+// setSessionVariables sets vars on conn, saving their previous values in user
+// variables. It returns a function restoring the previous values, which
+// discards the connection from the pool if they cannot be restored.
+func setSessionVariables(ctx context.Context, conn *sql.Conn, vars []sessionVariable) (func(), error) {
+	set := 0
+
+	restore := func() {
+		for i := set - 1; i >= 0; i-- {
+			q := fmt.Sprintf("SET SESSION %s = @mig_session_%d", vars[i].name, i)
+			if _, err := conn.ExecContext(ctx, q); err != nil {
+				// never hand a connection with the migration settings back to the pool
+				conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+				return
+			}
+		}
+	}
+
+	for i, v := range vars {
+		q := fmt.Sprintf("SET @mig_session_%d = @@SESSION.%s", i, v.name)
+		if _, err := conn.ExecContext(ctx, q); err != nil {
+			restore()
+			return nil, fmt.Errorf("error reading session variable %s: %w", v.name, err)
+		}
+		set = i + 1
+
+		q = fmt.Sprintf("SET SESSION %s = %s", v.name, v.value)
+		if _, err := conn.ExecContext(ctx, q); err != nil {
+			restore()
+			return nil, fmt.Errorf("error setting session variable %s: %w", v.name, err)
+		}
+	}
+
+	return restore, nil
+}
@@ -0,0 +1,37 @@
+package mig
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSessionVariables(t *testing.T) {
+
+	defer func(vars map[string]string) { SessionVariables = vars }(SessionVariables)
+	SessionVariables = map[string]string{"lock_wait_timeout": "10", "foreign_key_checks": "1"}
+
+	script := []byte(`-- +mig Set LOCK_WAIT_TIMEOUT = 5
+-- +mig Set sql_mode='STRICT_TRANS_TABLES,NO_ZERO_DATE'
+-- +mig Up
+SELECT 1;
+`)
+
+	got, err := sessionVariables(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sessionVariable{
+		{"foreign_key_checks", "1"},
+		{"LOCK_WAIT_TIMEOUT", "5"},
+		{"sql_mode", "'STRICT_TRANS_TABLES,NO_ZERO_DATE'"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect session variables. got %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"sql_mode", "=5", "lock wait=5", "@@lock_wait_timeout=5", "lock_wait_timeout="} {
+		if _, err := parseSetDirective(bad); err == nil {
+			t.Errorf("parseSetDirective(%q) succeeded, want an error", bad)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package mig
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrPartialSquash the database has applied some, but not all, of the
+// migrations replaced by a squashed migration
+var ErrPartialSquash = errors.New("database part-way through a squashed migration")
+
+// PartialSquashError is returned when a squashed migration is about to be
+// applied to a database that has applied some of the migrations it replaces,
+// which would create objects that already exist. It matches ErrPartialSquash
+// with errors.Is.
+type PartialSquashError struct {
+	Migration string  // file name of the squashed migration
+	Versions  []int64 // versions it replaces that are applied
+}
+
+func (e *PartialSquashError) Error() string {
+	return fmt.Sprintf("mig: versions %v replaced by %s are already applied, migrate the database with the archived migrations first", e.Versions, e.Migration)
+}
+
+// Is reports whether target is ErrPartialSquash
+func (e *PartialSquashError) Is(target error) bool {
+	return target == ErrPartialSquash
+}
+
+// squashedHeader starts a squashed migration, followed by a comment
+// line for each migration file it replaces.
+const squashedHeader = "-- mig squashed migration of:"
+
+// squashedVersions returns the versions of the migrations a squashed
+// migration script replaces, as listed in its header, or none if the
+// script is not a squashed migration.
+func squashedVersions(script []byte) ([]int64, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(script))
+	if !scanner.Scan() || scanner.Text() != squashedHeader {
+		return nil, scanner.Err()
+	}
+
+	var versions []int64
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "--   ") {
+			break
+		}
+
+		v, err := numericComponent(strings.TrimSpace(line[len("--"):]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid squashed migration %q: %v", line, err)
+		}
+		versions = append(versions, v)
+	}
+
+	return versions, scanner.Err()
+}
+
+// checkPartialSquash fails with a *PartialSquashError when source is a
+// squashed migration replacing versions already applied to the database.
+func checkPartialSquash(db *sql.DB, source string) error {
+	script, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+
+	squashed, err := squashedVersions(script)
+	if err != nil || len(squashed) == 0 {
+		return err
+	}
+
+	applied, err := getAppliedVersions(db)
+	if err != nil {
+		return err
+	}
+	isApplied := map[int64]bool{}
+	for _, v := range applied {
+		isApplied[v] = true
+	}
+
+	var versions []int64
+	for _, v := range squashed {
+		if isApplied[v] {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) > 0 {
+		return &PartialSquashError{Migration: filepath.Base(source), Versions: versions}
+	}
+
+	return nil
+}
+
+// isVersionTable reports whether name is one of the tables mig keeps
+// its own metadata in.
+func isVersionTable(name string) bool {
+	return name == VersionTable || name == VersionTable+"_meta" || name == VersionTable+"_batches"
+}
+
+// writeSquashedMigration writes objects to w as a migration script creating
+// them on Up and dropping them, in reverse order, on Down.
+func writeSquashedMigration(w io.Writer, objects []schemaObject, squashed migrations) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "%s\n", squashedHeader)
+	for _, m := range squashed {
+		fmt.Fprintf(bw, "--   %s\n", filepath.Base(m.source))
+	}
+
+	fmt.Fprintf(bw, "\n%sUp\n", sqlCmdPrefix)
+	fmt.Fprint(bw, "SET FOREIGN_KEY_CHECKS = 0;\n\n")
+	for _, o := range objects {
+		writeStatement(bw, o)
+	}
+	fmt.Fprint(bw, "SET FOREIGN_KEY_CHECKS = 1;\n\n")
+
+	fmt.Fprintf(bw, "%sDown\n", sqlCmdPrefix)
+	fmt.Fprint(bw, "SET FOREIGN_KEY_CHECKS = 0;\n")
+	for i := len(objects) - 1; i >= 0; i-- {
+		fmt.Fprintf(bw, "DROP %s %s;\n", objects[i].kind, quoteIdentifier(objects[i].name))
+	}
+	fmt.Fprint(bw, "SET FOREIGN_KEY_CHECKS = 1;\n")
+
+	return bw.Flush()
+}
+
+// squash replaces the migrations in dir below version before with a single
+// migration creating the schema they result in, as applied to the empty
+// scratch database. The originals are moved to archiveDir. Migrations
+// changing data cannot be squashed, since only the schema is recreated.
+//
+// The squashed migration takes the version of the last migration it
+// replaces, so databases already past that version are unaffected: the
+// versions it replaces, listed in its header, remain known to the source.
+// Applying it to a database part-way through them fails with
+// ErrPartialSquash.
+func squash(scratch *sql.DB, dir string, before int64, archiveDir string) (string, error) {
+	all, err := collectMigrations(dir, 0, math.MaxInt64)
+	if err != nil {
+		return "", err
+	}
+
+	var squashed migrations
+	for _, m := range all {
+		if m.version < before {
+			squashed = append(squashed, m)
+		}
+	}
+
+	last, err := squashed.last()
+	if err != nil {
+		return "", errNoMigration{}
+	}
+
+	// the squashed migration only recreates the schema
+	if err := checkSquashedData(squashed); err != nil {
+		return "", err
+	}
+
+	objects, err := listSchemaObjects(scratch)
+	if err != nil {
+		return "", err
+	}
+	if len(objects) > 0 {
+		return "", fmt.Errorf("scratch database: %v", ErrDatabaseNotEmpty)
+	}
+
+	if _, err := UpToDB(scratch, dir, last.version); err != nil {
+		return "", err
+	}
+
+	objects, err = dumpSchemaObjects(scratch)
+	if err != nil {
+		return "", err
+	}
+
+	var schema []schemaObject
+	for _, o := range objects {
+		if !isVersionTable(o.name) {
+			schema = append(schema, o)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeSquashedMigration(&buf, schema, squashed); err != nil {
+		return "", err
+	}
+
+	// write the squashed migration before archiving anything, moving it in
+	// place once the originals are archived, so that no crash or failure
+	// leaves dir without the migrations or a partial squashed migration
+	name := strings.SplitN(filepath.Base(last.source), "_", 2)[0] + "_squashed.sql"
+	path := filepath.Join(dir, name)
+	tmp, err := writeSynced(dir, "."+name+".*.tmp", buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	if err := archiveMigrations(squashed, archiveDir); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// checkSquashedData fails when one of the migrations being squashed changes
+// data, which the squashed migration, recreating the schema only, would lose.
+func checkSquashedData(squashed migrations) error {
+	for _, m := range squashed {
+		f, err := os.Open(m.source)
+		if err != nil {
+			return err
+		}
+		stmts, err := splitSQLStatements(f, true)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error reading migration %s: %v", filepath.Base(m.source), err)
+		}
+
+		for _, stmt := range stmts {
+			if isDML(stmt.query) {
+				return fmt.Errorf("migration %s changes data at line %d, which a squashed migration would not reproduce, squash the migrations before it instead", filepath.Base(m.source), stmt.line)
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeSynced writes b to a new file of dir named after pattern, as
+// ioutil.TempFile does, and flushes it to disk before returning its path.
+func writeSynced(dir, pattern string, b []byte) (string, error) {
+	f, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// archiveMigrations moves the migrations to archiveDir, moving those
+// already archived back when one of them cannot be.
+func archiveMigrations(squashed migrations, archiveDir string) error {
+	if err := os.MkdirAll(archiveDir, 0755); err != nil {
+		return err
+	}
+
+	for i, m := range squashed {
+		archived := filepath.Join(archiveDir, filepath.Base(m.source))
+		if err := os.Rename(m.source, archived); err != nil {
+			for _, m := range squashed[:i] {
+				os.Rename(filepath.Join(archiveDir, filepath.Base(m.source)), m.source)
+			}
+			return fmt.Errorf("error archiving migration %s: %v", filepath.Base(m.source), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package mig
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrThrottleTimeout replicas lagged behind for longer than Throttle.MaxWait
+var ErrThrottleTimeout = errors.New("timed out waiting for replication lag to recover")
+
+// ThrottleConfig configures the pausing of migrations
+// while replicas lag behind the primary.
+type ThrottleConfig struct {
+	Replicas       []string      // connection strings of the replicas to poll, throttling is disabled if empty
+	HeartbeatTable string        // pt-heartbeat style table with a ts column to measure lag with, instead of SHOW REPLICA STATUS
+	MaxLag         time.Duration // lag above which migrations pause
+	MaxWait        time.Duration // longest pause before the migration aborts with ErrThrottleTimeout
+	Interval       time.Duration // time between polls while paused
+}
+
+// Throttle configures the pausing of migrations while replicas lag behind
+// the primary. Migrations only pause while they hold no transaction open:
+// before each migration, around statements annotated with OnlineSchemaChange
+// or Batch, and between batch chunks.
+var Throttle = ThrottleConfig{
+	MaxLag:   10 * time.Second,
+	MaxWait:  30 * time.Minute,
+	Interval: time.Second,
+}
+
+var (
+	replicasMu sync.Mutex
+	replicaDBs = map[string]*sql.DB{}
+)
+
+// getReplicaDB returns the connection pool to a replica, opening it once.
+func getReplicaDB(conn string) (*sql.DB, error) {
+	replicasMu.Lock()
+	defer replicasMu.Unlock()
+
+	if db, ok := replicaDBs[conn]; ok {
+		return db, nil
+	}
+
+	db, err := getDB(conn)
+	if err != nil {
+		return nil, err
+	}
+	replicaDBs[conn] = db
+
+	return db, nil
+}
+
+// replicaName names a replica by its address in logs and errors.
+func replicaName(conn string) string {
+	cfg, err := mysql.ParseDSN(conn)
+	if err != nil {
+		return "replica"
+	}
+
+	return cfg.Addr
+}
+
+// heartbeatLag measures the lag of a replica from the most recent
+// timestamp written to the heartbeat table on the primary.
+func heartbeatLag(db *sql.DB, table string) (time.Duration, error) {
+	var lag sql.NullInt64
+	q := fmt.Sprintf("SELECT TIMESTAMPDIFF(MICROSECOND, MAX(ts), NOW(6)) FROM %s", table)
+	if err := db.QueryRow(q).Scan(&lag); err != nil {
+		return 0, err
+	}
+	if !lag.Valid {
+		return 0, fmt.Errorf("no heartbeat found in %s", table)
+	}
+
+	return time.Duration(lag.Int64) * time.Microsecond, nil
+}
+
+// replicaStatusLag measures the lag of a replica from SHOW REPLICA STATUS,
+// falling back to SHOW SLAVE STATUS on servers predating it. A stopped
+// replication thread is reported as an infinite lag.
+func replicaStatusLag(db *sql.DB) (time.Duration, error) {
+	rows, err := db.Query("SHOW REPLICA STATUS")
+	if err != nil {
+		rows, err = db.Query("SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errors.New("replication is not configured")
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+
+	for i, c := range columns {
+		if c != "Seconds_Behind_Source" && c != "Seconds_Behind_Master" {
+			continue
+		}
+		if !values[i].Valid {
+			return time.Duration(math.MaxInt64), nil
+		}
+
+		seconds, err := strconv.ParseInt(values[i].String, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return 0, errors.New("no replication lag reported")
+}
+
+// maxReplicaLag returns the largest lag among the configured replicas
+// along with the name of that replica.
+func maxReplicaLag() (time.Duration, string, error) {
+	var max time.Duration
+	var name string
+
+	for _, conn := range Throttle.Replicas {
+		db, err := getReplicaDB(conn)
+		if err != nil {
+			return 0, "", err
+		}
+
+		var lag time.Duration
+		if len(Throttle.HeartbeatTable) > 0 {
+			lag, err = heartbeatLag(db, Throttle.HeartbeatTable)
+		} else {
+			lag, err = replicaStatusLag(db)
+		}
+		if err != nil {
+			return 0, "", fmt.Errorf("error checking lag of %s: %v", replicaName(conn), err)
+		}
+
+		if lag >= max {
+			max, name = lag, replicaName(conn)
+		}
+	}
+
+	return max, name, nil
+}
+
+// throttle pauses while any configured replica lags behind by more than
+// Throttle.MaxLag.
+func throttle() error {
+	if len(Throttle.Replicas) == 0 {
+		return nil
+	}
+
+	return waitForLag(maxReplicaLag)
+}
+
+// waitForLag polls lag until it is no greater than Throttle.MaxLag,
+// giving up with ErrThrottleTimeout after Throttle.MaxWait.
+func waitForLag(lag func() (time.Duration, string, error)) error {
+	started := time.Now()
+
+	for {
+		current, name, err := lag()
+		if err != nil {
+			return err
+		}
+		if current <= Throttle.MaxLag {
+			return nil
+		}
+
+		if time.Since(started) >= Throttle.MaxWait {
+			return fmt.Errorf("%w: %s still lagging", ErrThrottleTimeout, name)
+		}
+
+		if current == time.Duration(math.MaxInt64) {
+			Log.Write([]byte(fmt.Sprintf("Throttled replication stopped on %s\n", name)))
+		} else {
+			Log.Write([]byte(fmt.Sprintf("Throttled %s lagging by %v\n", name, current)))
+		}
+		time.Sleep(Throttle.Interval)
+	}
+}
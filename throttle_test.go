@@ -0,0 +1,37 @@
+package mig
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForLag(t *testing.T) {
+
+	defer func(config ThrottleConfig) { Throttle = config }(Throttle)
+	Throttle = ThrottleConfig{MaxLag: time.Second, MaxWait: time.Minute, Interval: time.Millisecond}
+
+	// lag recovers after a few polls
+	lags := []time.Duration{5 * time.Second, 2 * time.Second, time.Second}
+	polls := 0
+	err := waitForLag(func() (time.Duration, string, error) {
+		lag := lags[polls]
+		polls++
+		return lag, "replica", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polls != len(lags) {
+		t.Errorf("incorrect number of polls. got %v, want %v", polls, len(lags))
+	}
+
+	// lag never recovers
+	Throttle.MaxWait = 10 * time.Millisecond
+	err = waitForLag(func() (time.Duration, string, error) {
+		return time.Hour, "replica", nil
+	})
+	if !errors.Is(err, ErrThrottleTimeout) {
+		t.Errorf("incorrect error. got %v, want %v", err, ErrThrottleTimeout)
+	}
+}
@@ -0,0 +1,129 @@
+package mig
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ErrIrreversibleMigration the Down section of a migration does not
+// restore the schema preceding its Up section
+var ErrIrreversibleMigration = errors.New("migration is not reversible")
+
+// IrreversibleMigrationError is returned by Verify with the differences
+// left by a migration that failed the round trip. It matches
+// ErrIrreversibleMigration with errors.Is.
+type IrreversibleMigrationError struct {
+	Migration   string
+	Direction   bool // false when Down did not restore the schema, true when Up did not apply the same schema again
+	Differences []SchemaDifference
+}
+
+func (e *IrreversibleMigrationError) Error() string {
+	var diffs []string
+	for _, d := range e.Differences {
+		diffs = append(diffs, d.String())
+	}
+
+	if e.Direction {
+		return fmt.Sprintf("mig: migration %s applied a different schema after going down:\n%s", e.Migration, strings.Join(diffs, "\n"))
+	}
+	return fmt.Sprintf("mig: migration %s did not restore the previous schema going down:\n%s", e.Migration, strings.Join(diffs, "\n"))
+}
+
+// Is reports whether target is ErrIrreversibleMigration
+func (e *IrreversibleMigrationError) Is(target error) bool {
+	return target == ErrIrreversibleMigration
+}
+
+// dumpSchemaWithoutVersionTables returns the definitions of the objects
+// of the database, leaving out the tables mig keeps its versions in.
+func dumpSchemaWithoutVersionTables(db *sql.DB) (*schemaDump, error) {
+	objects, err := dumpSchemaObjects(db)
+	if err != nil {
+		return nil, err
+	}
+
+	dump := &schemaDump{}
+	for _, o := range objects {
+		if !isVersionTable(o.name) {
+			dump.objects = append(dump.objects, o)
+		}
+	}
+
+	return dump, nil
+}
+
+// verify applies every pending migration with a round trip through its
+// Down section: up, down, then up again. It stops at the first migration
+// whose Down section leaves the schema different from before its Up
+// section, or whose second Up results in a different schema than its first.
+func verify(db *sql.DB, dir string) (int, error) {
+	if err := checkUnknownVersions(db, dir); err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	migrations, err := collectMigrations(dir, 0, math.MaxInt64)
+	if err != nil {
+		return count, err
+	}
+
+	for {
+		currentVersion, err := getVersion(db)
+		if err != nil {
+			return count, err
+		}
+
+		next, err := migrations.next(currentVersion)
+		// no migrations left to verify
+		if err != nil {
+			return count, nil
+		}
+
+		before, err := dumpSchemaWithoutVersionTables(db)
+		if err != nil {
+			return count, err
+		}
+
+		name, err := next.up(db)
+		if err != nil {
+			return count, err
+		}
+
+		applied, err := dumpSchemaWithoutVersionTables(db)
+		if err != nil {
+			return count, err
+		}
+
+		if _, err := next.down(db); err != nil {
+			return count, err
+		}
+
+		after, err := dumpSchemaWithoutVersionTables(db)
+		if err != nil {
+			return count, err
+		}
+		if diffs := diffSchemas(before, after); len(diffs) > 0 {
+			return count, &IrreversibleMigrationError{Migration: name, Differences: diffs}
+		}
+
+		if _, err := next.up(db); err != nil {
+			return count, err
+		}
+
+		after, err = dumpSchemaWithoutVersionTables(db)
+		if err != nil {
+			return count, err
+		}
+		if diffs := diffSchemas(applied, after); len(diffs) > 0 {
+			return count, &IrreversibleMigrationError{Migration: name, Direction: true, Differences: diffs}
+		}
+
+		Log.Write([]byte(fmt.Sprintf("Verified  %v\n", name)))
+		count++
+	}
+}
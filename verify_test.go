@@ -0,0 +1,27 @@
+package mig
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIrreversibleMigrationError(t *testing.T) {
+
+	err := fmt.Errorf("verify: %w", &IrreversibleMigrationError{
+		Migration: "2_add_title.sql",
+		Differences: diffSchemas(
+			&schemaDump{objects: []schemaObject{{kind: "TABLE", name: "post", definition: "CREATE TABLE `post` (\n  `id` int NOT NULL\n)"}}},
+			&schemaDump{objects: []schemaObject{{kind: "TABLE", name: "post", definition: "CREATE TABLE `post` (\n  `id` int NOT NULL,\n  `title` text\n)"}}},
+		),
+	})
+
+	if !errors.Is(err, ErrIrreversibleMigration) {
+		t.Error("error is not ErrIrreversibleMigration")
+	}
+
+	want := "verify: mig: migration 2_add_title.sql did not restore the previous schema going down:\nunexpected column post.title:\n  + `title` text"
+	if err.Error() != want {
+		t.Errorf("incorrect error.\ngot:\n%s\nwant:\n%s", err, want)
+	}
+}
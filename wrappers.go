@@ -4,8 +4,11 @@ import (
 	"database/sql"
 	"fmt"
 	"github.com/go-sql-driver/mysql"
+	"io"
+	"io/ioutil"
 	"math"
 	"path/filepath"
+	"sort"
 	"time"
 )
 
@@ -39,6 +42,10 @@ func Down(conn, dir string) (name string, err error) {
 // DownDB rolls back the version by one
 // Expects SetDialect to be called beforehand.
 func DownDB(db *sql.DB, dir string) (name string, err error) {
+	if err := checkUnknownVersions(db, dir); err != nil {
+		return "", err
+	}
+
 	currentVersion, err := getVersion(db)
 	if err != nil {
 		return "", err
@@ -77,6 +84,63 @@ func DownAll(conn, dir string) (int, error) {
 // Logs success messages to global writer variable Log.
 // Expects SetDialect to be called beforehand.
 func DownAllDB(db *sql.DB, dir string) (int, error) {
+	if err := checkUnknownVersions(db, dir); err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	migrations, err := collectMigrations(dir, 0, math.MaxInt64)
+	if err != nil {
+		return count, err
+	}
+
+	for {
+		currentVersion, err := getVersion(db)
+		if err != nil {
+			return count, err
+		}
+
+		current, err := migrations.current(currentVersion)
+		// no migrations left to run
+		if err != nil {
+			return count, nil
+		}
+
+		name, err := current.down(db)
+		if err != nil {
+			return count, err
+		}
+
+		Log.Write([]byte(fmt.Sprintf("Success   %v\n", name)))
+		count++
+	}
+}
+
+// DownTo rolls back the migrations above version.
+// Logs success messages to global writer variable Log.
+func DownTo(conn, dir string, version int64) (int, error) {
+	db, err := getDB(conn)
+	if err != nil {
+		return 0, err
+	}
+
+	err = setDialect()
+	if err != nil {
+		return 0, err
+	}
+
+	return DownToDB(db, dir, version)
+}
+
+// DownToDB rolls back the migrations above version.
+// Logs success messages to global writer variable Log.
+// Expects SetDialect to be called beforehand.
+func DownToDB(db *sql.DB, dir string, version int64) (int, error) {
+	if err := checkUnknownVersions(db, dir); err != nil {
+		return 0, err
+	}
+
 	count := 0
 
 	migrations, err := collectMigrations(dir, 0, math.MaxInt64)
@@ -89,6 +153,9 @@ func DownAllDB(db *sql.DB, dir string) (int, error) {
 		if err != nil {
 			return count, err
 		}
+		if currentVersion <= version {
+			return count, nil
+		}
 
 		current, err := migrations.current(currentVersion)
 		// no migrations left to run
@@ -124,9 +191,35 @@ func Up(conn, dir string) (int, error) {
 // UpDB migrates to the highest version available
 // Expects SetDialect to be called beforehand.
 func UpDB(db *sql.DB, dir string) (int, error) {
+	return UpToDB(db, dir, math.MaxInt64)
+}
+
+// UpTo migrates to the highest version available not above version
+func UpTo(conn, dir string, version int64) (int, error) {
+	db, err := getDB(conn)
+	if err != nil {
+		return 0, err
+	}
+
+	err = setDialect()
+	if err != nil {
+		return 0, err
+	}
+
+	return UpToDB(db, dir, version)
+}
+
+// UpToDB migrates to the highest version available not above version
+// Logs success messages to global writer variable Log.
+// Expects SetDialect to be called beforehand.
+func UpToDB(db *sql.DB, dir string, version int64) (int, error) {
+	if err := checkUnknownVersions(db, dir); err != nil {
+		return 0, err
+	}
+
 	count := 0
 
-	migrations, err := collectMigrations(dir, 0, math.MaxInt64)
+	migrations, err := collectMigrations(dir, 0, version)
 	if err != nil {
 		return count, err
 	}
@@ -171,6 +264,10 @@ func UpOne(conn, dir string) (name string, err error) {
 // UpOneDB migrates one version
 // Expects SetDialect to be called beforehand.
 func UpOneDB(db *sql.DB, dir string) (name string, err error) {
+	if err := checkUnknownVersions(db, dir); err != nil {
+		return "", err
+	}
+
 	currentVersion, err := getVersion(db)
 	if err != nil {
 		return "", err
@@ -207,6 +304,10 @@ func Redo(conn, dir string) (string, error) {
 // RedoDB re-runs the latest migration.
 // Expects SetDialect to be called beforehand.
 func RedoDB(db *sql.DB, dir string) (string, error) {
+	if err := checkUnknownVersions(db, dir); err != nil {
+		return "", err
+	}
+
 	currentVersion, err := getVersion(db)
 	if err != nil {
 		return "", err
@@ -231,8 +332,12 @@ func RedoDB(db *sql.DB, dir string) (string, error) {
 
 // MigrationStatus show the status of the migration
 type MigrationStatus struct {
-	Applied string
-	Name    string
+	Applied  string `json:"applied"`
+	Name     string `json:"name"`
+	Version  int64  `json:"version"`
+	Checksum string `json:"checksum,omitempty"` // checksum of the script when it was applied, empty if recorded by an older mig
+	Modified bool   `json:"modified"`           // the script changed since it was applied
+	Orphaned bool   `json:"orphaned"`           // the version is applied but has no migration file, Name is empty
 }
 
 // Status returns the status of each migration
@@ -268,11 +373,42 @@ func StatusDB(db *sql.DB, dir string) ([]MigrationStatus, error) {
 	}
 
 	for _, migration := range migrations {
-		s = append(s, MigrationStatus{
-			Applied: getMigrationStatus(db, migration.version),
-			Name:    filepath.Base(migration.source),
-		})
+		applied, sum, err := getMigrationStatus(db, migration.version)
+		if err != nil {
+			return s, err
+		}
+
+		status := MigrationStatus{
+			Applied:  applied,
+			Name:     filepath.Base(migration.source),
+			Version:  migration.version,
+			Checksum: sum,
+		}
+
+		if applied != "Pending" && len(sum) > 0 {
+			script, err := ioutil.ReadFile(migration.source)
+			if err != nil {
+				return s, err
+			}
+			status.Modified = checksum(script) != sum
+		}
+
+		s = append(s, status)
+	}
+
+	unknown, err := unknownVersions(db, dir)
+	if err != nil {
+		return s, err
+	}
+	for _, v := range unknown {
+		applied, sum, err := getMigrationStatus(db, v)
+		if err != nil {
+			return s, err
+		}
+
+		s = append(s, MigrationStatus{Applied: applied, Version: v, Checksum: sum, Orphaned: true})
 	}
+	sort.SliceStable(s, func(i, j int) bool { return s[i].Version < s[j].Version })
 
 	return s, nil
 }
@@ -298,6 +434,194 @@ func VersionDB(db *sql.DB) (int64, error) {
 	return getVersion(db)
 }
 
+// DumpSchema writes a deterministic snapshot of the database schema,
+// including the applied migration versions, to w.
+func DumpSchema(conn string, w io.Writer) error {
+	db, err := getDB(conn)
+	if err != nil {
+		return err
+	}
+
+	err = setDialect()
+	if err != nil {
+		return err
+	}
+
+	return DumpSchemaDB(db, w)
+}
+
+// DumpSchemaDB writes a deterministic snapshot of the database schema,
+// including the applied migration versions, to w.
+// Expects SetDialect to be called beforehand
+func DumpSchemaDB(db *sql.DB, w io.Writer) error {
+	dump, err := getSchemaDump(db)
+	if err != nil {
+		return err
+	}
+
+	return dump.write(w)
+}
+
+// LoadSchema loads a schema dump into an empty database,
+// returning the migration version the database is now at.
+func LoadSchema(conn string, r io.Reader) (int64, error) {
+	db, err := getDB(conn)
+	if err != nil {
+		return 0, err
+	}
+
+	err = setDialect()
+	if err != nil {
+		return 0, err
+	}
+
+	return LoadSchemaDB(db, r)
+}
+
+// LoadSchemaDB loads a schema dump into an empty database,
+// returning the migration version the database is now at.
+// Expects SetDialect to be called beforehand
+func LoadSchemaDB(db *sql.DB, r io.Reader) (int64, error) {
+	if err := loadSchema(db, r); err != nil {
+		return 0, err
+	}
+
+	return getVersion(db)
+}
+
+// Squash replaces the migrations in dir below version before with a single
+// migration creating the schema they result in, built by applying them to
+// the empty scratch database. The originals are moved to archiveDir.
+func Squash(scratchConn, dir string, before int64, archiveDir string) (string, error) {
+	db, err := getDB(scratchConn)
+	if err != nil {
+		return "", err
+	}
+
+	err = setDialect()
+	if err != nil {
+		return "", err
+	}
+
+	return SquashDB(db, dir, before, archiveDir)
+}
+
+// SquashDB replaces the migrations in dir below version before with a single
+// migration creating the schema they result in, built by applying them to
+// the empty scratch database. The originals are moved to archiveDir.
+// Expects SetDialect to be called beforehand
+func SquashDB(scratch *sql.DB, dir string, before int64, archiveDir string) (string, error) {
+	return squash(scratch, dir, before, archiveDir)
+}
+
+// Drift compares the schema of the database against the expected schema
+// dump read from expected, and returns every difference found.
+func Drift(conn string, expected io.Reader) ([]SchemaDifference, error) {
+	db, err := getDB(conn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = setDialect()
+	if err != nil {
+		return nil, err
+	}
+
+	return DriftDB(db, expected)
+}
+
+// DriftDB compares the schema of the database against the expected schema
+// dump read from expected, and returns every difference found.
+// Expects SetDialect to be called beforehand
+func DriftDB(db *sql.DB, expected io.Reader) ([]SchemaDifference, error) {
+	expectedDump, err := readSchemaDump(expected)
+	if err != nil {
+		return nil, err
+	}
+
+	actualDump, err := getSchemaDump(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffSchemas(expectedDump, actualDump), nil
+}
+
+// DriftBetween compares the schema of the database against the schema of
+// the expected database, and returns every difference found.
+func DriftBetween(conn, expectedConn string) ([]SchemaDifference, error) {
+	db, err := getDB(conn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	expected, err := getDB(expectedConn)
+	if err != nil {
+		return nil, err
+	}
+	defer expected.Close()
+
+	err = setDialect()
+	if err != nil {
+		return nil, err
+	}
+
+	return DriftBetweenDB(db, expected)
+}
+
+// DriftBetweenDB compares the schema of the database against the schema of
+// the expected database, and returns every difference found.
+// Expects SetDialect to be called beforehand
+func DriftBetweenDB(db, expected *sql.DB) ([]SchemaDifference, error) {
+	expectedDump, err := getSchemaDump(expected)
+	if err != nil {
+		return nil, err
+	}
+
+	actualDump, err := getSchemaDump(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffSchemas(expectedDump, actualDump), nil
+}
+
+// Verify applies every pending migration through an up, down and up again
+// round trip, checking that each Down section restores the schema preceding
+// its Up section. It stops with an *IrreversibleMigrationError at the first
+// migration that does not.
+func Verify(conn, dir string) (int, error) {
+	db, err := getDB(conn)
+	if err != nil {
+		return 0, err
+	}
+
+	err = setDialect()
+	if err != nil {
+		return 0, err
+	}
+
+	return VerifyDB(db, dir)
+}
+
+// VerifyDB applies every pending migration through an up, down and up again
+// round trip, checking that each Down section restores the schema preceding
+// its Up section. It stops with an *IrreversibleMigrationError at the first
+// migration that does not.
+// Expects SetDialect to be called beforehand
+func VerifyDB(db *sql.DB, dir string) (int, error) {
+	return verify(db, dir)
+}
+
+// Open returns a connection pool to the database, configured as the
+// functions taking a connection string configure theirs, to be used
+// with the functions taking a *sql.DB.
+func Open(conn string) (*sql.DB, error) {
+	return getDB(conn)
+}
+
 // getDB returns db using sql.Open
 // This is to enable hard coding the DSN Config
 func getDB(conn string) (*sql.DB, error) {